@@ -2,6 +2,7 @@ package dbresolver
 
 import (
 	"math/rand"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -45,3 +46,54 @@ func (r *RandomBalancer) Get() int64 {
 
 	return rand.Int63n(r.resourceCount)
 }
+
+// WeightedBalancer spreads picks in proportion to the weights,
+// interleaving them smoothly rather than in runs, like nginx.
+type WeightedBalancer struct {
+	mu      *sync.Mutex
+	weights []int64
+	current []int64
+	total   int64
+}
+
+// NewWeightedBalancer takes one weight per replica.
+// Weights below 1 count as 1.
+func NewWeightedBalancer(weights []int) *WeightedBalancer {
+	wb := &WeightedBalancer{
+		mu:      &sync.Mutex{},
+		weights: make([]int64, len(weights)),
+		current: make([]int64, len(weights)),
+	}
+
+	for i, weight := range weights {
+		if weight < 1 {
+			weight = 1
+		}
+
+		wb.weights[i] = int64(weight)
+		wb.total += int64(weight)
+	}
+
+	return wb
+}
+
+func (wb *WeightedBalancer) Get() int64 {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	if len(wb.weights) == 0 {
+		return 0
+	}
+
+	best := 0
+	for i, weight := range wb.weights {
+		wb.current[i] += weight
+
+		if wb.current[i] > wb.current[best] {
+			best = i
+		}
+	}
+
+	wb.current[best] -= wb.total
+	return int64(best)
+}
@@ -0,0 +1,192 @@
+package dbresolver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+)
+
+// Column describes a column of a query result, from sql.ColumnType.
+// The Has fields tell whether the driver reported the field before them.
+type Column struct {
+	Name         string `json:"name"`
+	DatabaseType string `json:"database_type"`
+
+	Nullable    bool `json:"nullable"`
+	HasNullable bool `json:"-"`
+
+	// Length is the length of variable length types, like text
+	Length    int64 `json:"length,omitempty"`
+	HasLength bool  `json:"-"`
+
+	Precision         int64 `json:"precision,omitempty"`
+	Scale             int64 `json:"scale,omitempty"`
+	HasPrecisionScale bool  `json:"-"`
+
+	// ScanType is the Go type the driver scans the column into
+	ScanType reflect.Type `json:"-"`
+}
+
+type Columns []Column
+
+func newColumns(types []*sql.ColumnType) Columns {
+	columns := make(Columns, 0, len(types))
+
+	for _, ct := range types {
+		column := Column{
+			Name:         ct.Name(),
+			DatabaseType: ct.DatabaseTypeName(),
+			ScanType:     ct.ScanType(),
+		}
+
+		column.Nullable, column.HasNullable = ct.Nullable()
+		column.Length, column.HasLength = ct.Length()
+		column.Precision, column.Scale, column.HasPrecisionScale = ct.DecimalSize()
+
+		columns = append(columns, column)
+	}
+
+	return columns
+}
+
+// Names lists the column names, in order.
+func (c Columns) Names() []string {
+	names := make([]string, 0, len(c))
+	for _, column := range c {
+		names = append(names, column.Name)
+	}
+
+	return names
+}
+
+// Index is the position of the first column named name, or -1.
+func (c Columns) Index(name string) int {
+	for i, column := range c {
+		if column.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// ResultSet is a materialized query result, which keeps the columns
+// Rows drops. It is what the koalescer shares between callers, so
+// that cached results describe themselves.
+type ResultSet struct {
+	Columns Columns
+	Rows    Rows
+}
+
+// ToResultSet reads every row, and closes rows.
+func ToResultSet(rows *sql.Rows) (*ResultSet, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	result, err := ToRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResultSet{Columns: newColumns(types), Rows: result}, nil
+}
+
+// ToRecord reads the first row with its columns, and closes rows.
+// It returns sql.ErrNoRows when there is none.
+func ToRecord(rows *sql.Rows) (*Record, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	row, err := ToRow(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Record{Columns: newColumns(types), Values: *row}, nil
+}
+
+// sameRows tells whether b is a, and not rows made by an interceptor
+func sameRows(a, b Rows) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+func (rs *ResultSet) Len() int {
+	return len(rs.Rows)
+}
+
+// Record is the i-th row, with the columns of the result set.
+func (rs *ResultSet) Record(i int) Record {
+	return Record{Columns: rs.Columns, Values: *rs.Rows[i]}
+}
+
+func (rs *ResultSet) Records() []Record {
+	records := make([]Record, 0, len(rs.Rows))
+	for i := range rs.Rows {
+		records = append(records, rs.Record(i))
+	}
+
+	return records
+}
+
+// Maps returns a map per row, keyed by column name.
+func (rs *ResultSet) Maps() []map[string]interface{} {
+	maps := make([]map[string]interface{}, 0, len(rs.Rows))
+	for i := range rs.Rows {
+		maps = append(maps, rs.Record(i).Map())
+	}
+
+	return maps
+}
+
+// JSON encodes the rows as an array of objects keyed by column name.
+func (rs *ResultSet) JSON() ([]byte, error) {
+	return json.Marshal(rs.Maps())
+}
+
+// Record is a row with its columns.
+type Record struct {
+	Columns Columns
+	Values  Row
+}
+
+// Get returns the value of the column named name.
+func (r Record) Get(name string) (interface{}, bool) {
+	i := r.Columns.Index(name)
+	if i < 0 || i >= len(r.Values) {
+		return nil, false
+	}
+
+	return r.Values[i], true
+}
+
+// Map returns the values keyed by column name. Text read as
+// []byte is returned as a string.
+func (r Record) Map() map[string]interface{} {
+	values := make(map[string]interface{}, len(r.Columns))
+
+	for i, column := range r.Columns {
+		if i >= len(r.Values) {
+			break
+		}
+
+		value := r.Values[i]
+		if text, ok := value.([]byte); ok {
+			value = string(text)
+		}
+
+		values[column.Name] = value
+	}
+
+	return values
+}
+
+// JSON encodes the record as an object keyed by column name.
+func (r Record) JSON() ([]byte, error) {
+	return json.Marshal(r.Map())
+}
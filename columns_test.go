@@ -0,0 +1,89 @@
+package dbresolver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultSet(t *testing.T) {
+	t.Run("keeps column names and types", func(t *testing.T) {
+		database := setupTypedDB(t)
+
+		result, err := database.QueryResultSet(`SELECT id, name, score FROM users ORDER BY id`)
+		require.NoError(t, err)
+		require.Equal(t, 2, result.Len())
+
+		require.Equal(t, []string{"id", "name", "score"}, result.Columns.Names())
+		require.Equal(t, "INTEGER", result.Columns[0].DatabaseType)
+		require.Equal(t, "TEXT", result.Columns[1].DatabaseType)
+		require.Equal(t, "REAL", result.Columns[2].DatabaseType)
+		require.Equal(t, 2, result.Columns.Index("score"))
+		require.Equal(t, -1, result.Columns.Index("missing"))
+
+		record := result.Record(0)
+
+		name, ok := record.Get("name")
+		require.True(t, ok)
+		require.Equal(t, "jane", name)
+
+		_, ok = record.Get("missing")
+		require.False(t, ok)
+
+		require.Equal(t, map[string]interface{}{"id": int64(1), "name": "jane", "score": 4.5}, record.Map())
+
+		encoded, err := record.JSON()
+		require.NoError(t, err)
+		require.JSONEq(t, `{"id": 1, "name": "jane", "score": 4.5}`, string(encoded))
+
+		encoded, err = result.JSON()
+		require.NoError(t, err)
+		require.JSONEq(t, `[{"id": 1, "name": "jane", "score": 4.5}, {"id": 2, "name": "john", "score": null}]`, string(encoded))
+
+		require.Len(t, result.Records(), 2)
+	})
+
+	t.Run("koalesced results describe themselves", func(t *testing.T) {
+		store := hooks.NewEventStore()
+		after := collect(store, dbresolver.EventAfterQueryRun)
+
+		database := setupTypedDB(t, dbresolver.WithHooks(store), dbresolver.WithQueryQualescer(
+			dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour)),
+		))
+
+		stmt := `SELECT id, name FROM users ORDER BY id`
+
+		rows, err := database.Query(stmt)
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+
+		result, err := database.QueryResultSetContext(context.Background(), stmt)
+		require.NoError(t, err)
+		require.True(t, (*after)[1].(dbresolver.AfterQueryEvent).Coalesced)
+		require.Equal(t, []string{"id", "name"}, result.Columns.Names())
+
+		// QueryRow keeps its own results
+		row, err := database.QueryRow(stmt).Values()
+		require.NoError(t, err)
+		require.Equal(t, "jane", (*row)[1])
+	})
+
+	t.Run("rows made by interceptors have no columns", func(t *testing.T) {
+		cached := dbresolver.Rows{&dbresolver.Row{"cached"}}
+
+		database := setupTypedDB(t, dbresolver.WithInterceptors(
+			func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				return dbresolver.QueryResult{Rows: cached}, nil
+			},
+		))
+
+		result, err := database.QueryResultSet(`SELECT name FROM users`)
+		require.NoError(t, err)
+		require.Empty(t, result.Columns)
+		require.Equal(t, cached, result.Rows)
+	})
+}
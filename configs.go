@@ -1,6 +1,15 @@
 package dbresolver
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-batteries/dbresolver/internal/multierr"
+)
 
 func SetDBDefaults(db *sql.DB) {
 	db.SetMaxIdleConns(DEFAULT_MAX_IDLE_CONNECTIONS)
@@ -21,3 +30,127 @@ func SetConfigDefaults(db *sql.DB, config *DBConfig) {
 		db.SetConnMaxIdleTime(*config.ConnectionMaxLifetime)
 	}
 }
+
+var (
+	ErrorNilMaster        = errors.New("master db cannot be nil")
+	ErrorNilReplica       = errors.New("replica db cannot be nil")
+	ErrorDuplicateName    = errors.New("node name is not unique")
+	ErrorNegativePoolSize = errors.New("pool size cannot be negative")
+	ErrorNegativeLifetime = errors.New("connection lifetime cannot be negative")
+	ErrorReplicaIsMaster  = errors.New("replica shares the master *sql.DB")
+)
+
+// ConfigErrors lists every problem found by DBConfig.Validate.
+// Each error wraps one of the ErrorXxx values above.
+type ConfigErrors []error
+
+func (errs ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+func (errs ConfigErrors) Unwrap() []error {
+	return errs
+}
+
+// Is reports whether one of the errors matches target.
+func (errs ConfigErrors) Is(target error) bool {
+	return multierr.Is(errs, target)
+}
+
+// As finds the first of the errors matching target.
+func (errs ConfigErrors) As(target interface{}) bool {
+	return multierr.As(errs, target)
+}
+
+// Validate checks the nodes and pool sizes of the config.
+// It returns ConfigErrors, or nil when the config is valid.
+func (cfg *DBConfig) Validate() error {
+	var errs ConfigErrors
+
+	if cfg.Master == nil || cfg.Master.DB == nil {
+		errs = append(errs, ErrorNilMaster)
+	}
+
+	names := map[string]bool{}
+	if cfg.Master != nil {
+		names[cfg.Master.Name] = true
+	}
+
+	for i, replica := range cfg.Replicas {
+		if replica == nil || replica.DB == nil {
+			errs = append(errs, fmt.Errorf("replicas[%d]: %w", i, ErrorNilReplica))
+			continue
+		}
+
+		if names[replica.Name] {
+			errs = append(errs, fmt.Errorf("replicas[%d] %q: %w", i, replica.Name, ErrorDuplicateName))
+		}
+		names[replica.Name] = true
+
+		if cfg.Master != nil && cfg.Master.DB != nil && replica.DB == cfg.Master.DB {
+			errs = append(errs, fmt.Errorf("replicas[%d] %q: %w", i, replica.Name, ErrorReplicaIsMaster))
+		}
+	}
+
+	if cfg.MaxIdleConnections != nil && *cfg.MaxIdleConnections < 0 {
+		errs = append(errs, fmt.Errorf("MaxIdleConnections: %w", ErrorNegativePoolSize))
+	}
+
+	if cfg.MaxOpenConnections != nil && *cfg.MaxOpenConnections < 0 {
+		errs = append(errs, fmt.Errorf("MaxOpenConnections: %w", ErrorNegativePoolSize))
+	}
+
+	if cfg.ConnectionMaxLifetime != nil && *cfg.ConnectionMaxLifetime < 0 {
+		errs = append(errs, fmt.Errorf("ConnectionMaxLifetime: %w", ErrorNegativeLifetime))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// PingError is returned by New when a node
+// does not answer the startup ping.
+type PingError struct {
+	Node string
+	Err  error
+}
+
+func (e *PingError) Error() string {
+	return fmt.Sprintf("ping %s: %v", e.Node, e.Err)
+}
+
+func (e *PingError) Unwrap() error {
+	return e.Err
+}
+
+// WithStartupPing makes New ping every node, each
+// bounded by timeout, and fail if one is down.
+func WithStartupPing(timeout time.Duration) DataBaseOpts {
+	return func(d *Database) {
+		d.startupPing = timeout
+	}
+}
+
+func (d *Database) pingAll() error {
+	nodes := d.Nodes()
+
+	for _, node := range nodes {
+		ctx, cancel := context.WithTimeout(context.Background(), d.startupPing)
+		err := node.CheckHealth(ctx)
+		cancel()
+
+		if err != nil {
+			return &PingError{Node: node.Name, Err: err}
+		}
+	}
+
+	return nil
+}
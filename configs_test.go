@@ -0,0 +1,134 @@
+package dbresolver_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func openDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestNew(t *testing.T) {
+	master := openDB(t, "./tmp/master.db")
+	replica := openDB(t, "./tmp/replica.db")
+
+	t.Run("valid config", func(t *testing.T) {
+		database, err := dbresolver.New(dbresolver.DBConfig{
+			Master:   dbresolver.AsMaster(master, "write"),
+			Replicas: []*dbresolver.ResolverDB{dbresolver.AsSyncReplica(replica, "read")},
+		}, dbresolver.WithStartupPing(time.Second))
+
+		require.NoError(t, err)
+		require.Equal(t, dbresolver.DbReadMode, *database.Config.DefaultMode)
+	})
+
+	t.Run("nil master", func(t *testing.T) {
+		_, err := dbresolver.New(dbresolver.DBConfig{})
+		require.ErrorIs(t, err, dbresolver.ErrorNilMaster)
+
+		_, err = dbresolver.New(dbresolver.DBConfig{Master: dbresolver.AsMaster(nil, "write")})
+		require.ErrorIs(t, err, dbresolver.ErrorNilMaster)
+	})
+
+	t.Run("every problem is reported", func(t *testing.T) {
+		_, err := dbresolver.New(dbresolver.DBConfig{
+			Master: dbresolver.AsMaster(master, "write"),
+			Replicas: []*dbresolver.ResolverDB{
+				nil,
+				dbresolver.AsReplica(replica, "write"),
+				dbresolver.AsReplica(master, "read"),
+			},
+			MaxOpenConnections: dbresolver.ToPtr(-1),
+		})
+
+		var errs dbresolver.ConfigErrors
+		require.True(t, errors.As(err, &errs))
+		require.Len(t, errs, 4)
+
+		require.ErrorIs(t, err, dbresolver.ErrorNilReplica)
+		require.ErrorIs(t, err, dbresolver.ErrorDuplicateName)
+		require.ErrorIs(t, err, dbresolver.ErrorReplicaIsMaster)
+		require.ErrorIs(t, err, dbresolver.ErrorNegativePoolSize)
+		require.Contains(t, err.Error(), `replicas[2] "read"`)
+	})
+
+	t.Run("negative lifetime", func(t *testing.T) {
+		lifetime := -time.Second
+
+		_, err := dbresolver.New(dbresolver.DBConfig{
+			Master:                dbresolver.AsMaster(master, "write"),
+			ConnectionMaxLifetime: &lifetime,
+		})
+		require.ErrorIs(t, err, dbresolver.ErrorNegativeLifetime)
+		require.NotErrorIs(t, err, dbresolver.ErrorNegativePoolSize)
+		require.Contains(t, err.Error(), "ConnectionMaxLifetime")
+	})
+
+	t.Run("startup ping", func(t *testing.T) {
+		closed := openDB(t, "./tmp/replica.db")
+		require.NoError(t, closed.Close())
+
+		_, err := dbresolver.New(dbresolver.DBConfig{
+			Master:   dbresolver.AsMaster(master, "write"),
+			Replicas: []*dbresolver.ResolverDB{dbresolver.AsSyncReplica(closed, "read")},
+		}, dbresolver.WithStartupPing(time.Second))
+
+		var pingErr *dbresolver.PingError
+		require.True(t, errors.As(err, &pingErr))
+		require.Equal(t, "read", pingErr.Node)
+	})
+
+	t.Run("balancer follows the policy", func(t *testing.T) {
+		database, err := dbresolver.New(dbresolver.DBConfig{
+			Master:   dbresolver.AsMaster(master, "write"),
+			Replicas: []*dbresolver.ResolverDB{dbresolver.AsSyncReplica(replica, "read")},
+			Policy:   &dbresolver.RandomBalancer{},
+		})
+		require.NoError(t, err)
+		require.IsType(t, &dbresolver.RandomBalancer{}, database.Config.Policy)
+
+		database, err = dbresolver.New(dbresolver.DBConfig{
+			Master: dbresolver.AsMaster(master, "write"),
+			Policy: &dbresolver.RandomBalancer{},
+		})
+		require.NoError(t, err)
+		require.IsType(t, &dbresolver.RoundRobalancer{}, database.Config.Policy)
+	})
+
+	t.Run("register follows the policy", func(t *testing.T) {
+		weighted := dbresolver.NewWeightedBalancer([]int{1})
+
+		policies := map[dbresolver.Balancer]dbresolver.Balancer{
+			nil:                           &dbresolver.RoundRobalancer{},
+			&dbresolver.RoundRobalancer{}: &dbresolver.RoundRobalancer{},
+			&dbresolver.RandomBalancer{}:  &dbresolver.RandomBalancer{},
+			weighted:                      weighted,
+		}
+
+		for policy, expected := range policies {
+			database := dbresolver.Register(dbresolver.DBConfig{
+				Master:   dbresolver.AsMaster(master, "write"),
+				Replicas: []*dbresolver.ResolverDB{dbresolver.AsSyncReplica(replica, "read")},
+				Policy:   policy,
+			})
+
+			require.IsType(t, expected, database.Config.Policy)
+			if expected == weighted {
+				require.Same(t, weighted, database.Config.Policy)
+			}
+		}
+	})
+}
@@ -0,0 +1,305 @@
+package dbresolver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrorNotRotating = errors.New("node is not opened with a RotatingConnector")
+
+// Credentials are fetched from a CredentialProvider
+// for every new connection of a RotatingConnector.
+type Credentials struct {
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+}
+
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+type CredentialProviderFunc func(ctx context.Context) (Credentials, error)
+
+func (f CredentialProviderFunc) Credentials(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// FileCredentialProvider reads the credentials from a YAML or JSON file,
+// every time they are needed, which makes rotation easy to try locally.
+//
+//	username: app
+//	password: secret
+type FileCredentialProvider struct {
+	Path string
+}
+
+func NewFileCredentialProvider(path string) *FileCredentialProvider {
+	return &FileCredentialProvider{Path: path}
+}
+
+func (p *FileCredentialProvider) Credentials(ctx context.Context) (Credentials, error) {
+	creds := Credentials{}
+
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return creds, err
+	}
+
+	err = yaml.Unmarshal(data, &creds)
+	return creds, err
+}
+
+// ConnectorFactory builds a connector from fresh credentials.
+type ConnectorFactory func(ctx context.Context, creds Credentials) (driver.Connector, error)
+
+// DSNConnector is a ConnectorFactory for drivers configured with a dsn.
+//
+//	dbresolver.DSNConnector(&pq.Driver{}, func(creds dbresolver.Credentials) string {
+//	    return fmt.Sprintf("postgres://%s:%s@replica-a/app", creds.Username, creds.Password)
+//	})
+func DSNConnector(d driver.Driver, dsn func(creds Credentials) string) ConnectorFactory {
+	return func(ctx context.Context, creds Credentials) (driver.Connector, error) {
+		if dc, ok := d.(driver.DriverContext); ok {
+			return dc.OpenConnector(dsn(creds))
+		}
+
+		return dsnConnector{dsn: dsn(creds), driver: d}, nil
+	}
+}
+
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// RotatingConnector opens every connection with the credentials of
+// its provider at that time. After Rotate, connections opened before
+// are closed instead of being reused, once they are idle.
+type RotatingConnector struct {
+	generation uint64
+
+	factory  ConnectorFactory
+	provider CredentialProvider
+}
+
+func NewRotatingConnector(factory ConnectorFactory, provider CredentialProvider) *RotatingConnector {
+	return &RotatingConnector{factory: factory, provider: provider}
+}
+
+// OpenRotating opens a *sql.DB whose connections use fresh credentials,
+// for NewResolveDB, AsMaster or AsReplica.
+func OpenRotating(factory ConnectorFactory, provider CredentialProvider) *sql.DB {
+	return sql.OpenDB(NewRotatingConnector(factory, provider))
+}
+
+func (c *RotatingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	generation := atomic.LoadUint64(&c.generation)
+
+	creds, err := c.provider.Credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := c.factory(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rotatingConn{Conn: conn, generation: generation, connector: c}, nil
+}
+
+func (c *RotatingConnector) Driver() driver.Driver {
+	return rotatingDriver{c}
+}
+
+// Rotate retires the connections opened so far. Idle connections are
+// closed when next picked from the pool, and busy ones when released.
+// ResolverDB.RotateCredentials also recycles the idle ones right away.
+func (c *RotatingConnector) Rotate() {
+	atomic.AddUint64(&c.generation, 1)
+}
+
+type rotatingDriver struct {
+	connector *RotatingConnector
+}
+
+// Open ignores name, the connector builds the dsn
+func (d rotatingDriver) Open(name string) (driver.Conn, error) {
+	return d.connector.Connect(context.Background())
+}
+
+// rotatingConn forwards to the driver connection, returning
+// driver.ErrSkip where the driver lacks an optional interface
+type rotatingConn struct {
+	driver.Conn
+
+	generation uint64
+	connector  *RotatingConnector
+}
+
+func (c *rotatingConn) stale() bool {
+	return c.generation != atomic.LoadUint64(&c.connector.generation)
+}
+
+func (c *rotatingConn) ResetSession(ctx context.Context) error {
+	if c.stale() {
+		return driver.ErrBadConn
+	}
+
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (c *rotatingConn) IsValid() bool {
+	if c.stale() {
+		return false
+	}
+
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+
+	return true
+}
+
+func (c *rotatingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+
+	return c.Conn.Prepare(query)
+}
+
+func (c *rotatingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("driver does not support transaction options")
+	}
+
+	return c.Conn.Begin() //nolint:staticcheck
+}
+
+func (c *rotatingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := c.Conn.(driver.ExecerContext); ok {
+		return execer.ExecContext(ctx, query, args)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+func (c *rotatingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := c.Conn.(driver.QueryerContext); ok {
+		return queryer.QueryContext(ctx, query, args)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+func (c *rotatingConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (c *rotatingConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+
+	return driver.ErrSkip
+}
+
+// RotateCredentials retires the connections of the node, which must
+// be opened with a RotatingConnector, and recycles the idle ones.
+func (rd *ResolverDB) RotateCredentials() error {
+	return rd.rotateCredentials(context.Background())
+}
+
+func (rd *ResolverDB) rotateCredentials(ctx context.Context) error {
+	d, ok := rd.DB.Driver().(rotatingDriver)
+	if !ok {
+		return ErrorNotRotating
+	}
+
+	d.connector.Rotate()
+	return recycleIdle(ctx, rd.DB)
+}
+
+// recycleIdle replaces the idle connections of db with new ones. database/sql
+// resets the connections taken from the pool, which fails for the retired
+// ones, so that they are closed instead of kept idle until reused.
+func recycleIdle(ctx context.Context, db *sql.DB) error {
+	idle := db.Stats().Idle
+	conns := make([]*sql.Conn, 0, idle)
+
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	for i := 0; i < idle && db.Stats().Idle > 0; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+
+		conns = append(conns, conn)
+	}
+
+	return nil
+}
+
+// RotateCredentials retires the connections of every node opened
+// with a RotatingConnector, so that new ones use fresh credentials.
+// It emits EventCredentialsRotated with the nodes rotated.
+func (d *Database) RotateCredentials(ctx context.Context) {
+	event := CredentialsRotatedEvent{}
+
+	// the nodes are held until rotated, so that
+	// a reload does not close them meanwhile
+	for _, node := range d.acquireNodes() {
+		err := node.rotateCredentials(ctx)
+		node.release()
+
+		if errors.Is(err, ErrorNotRotating) {
+			continue
+		}
+
+		if err != nil {
+			d.logger.Warn("dbresolver: recycling idle connections failed", "node", node.Name, "err", err)
+		}
+
+		event.Nodes = append(event.Nodes, node.Name)
+	}
+
+	d.logger.Info("dbresolver: credentials rotated", "nodes", event.Nodes)
+	d.Hooks.EmitContext(ctx, EventCredentialsRotated, event)
+}
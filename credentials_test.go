@@ -0,0 +1,173 @@
+package dbresolver_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "credentials.yaml")
+
+	writeCredentials := func(username string) {
+		require.NoError(t, os.WriteFile(path, []byte("username: "+username+"\npassword: secret\n"), 0o600))
+	}
+
+	// the username picks the database file, so that
+	// the credentials of a connection can be observed
+	factory := dbresolver.DSNConnector(&sqlite3.SQLiteDriver{}, func(creds dbresolver.Credentials) string {
+		return "file:" + filepath.Join(dir, creds.Username+".db")
+	})
+
+	databaseFile := func(db *dbresolver.Database) string {
+		row, err := db.QueryRow(`PRAGMA database_list`).Values()
+		require.NoError(t, err)
+
+		return filepath.Base(fmt.Sprint((*row)[2]))
+	}
+
+	t.Run("uses fresh credentials after rotation", func(t *testing.T) {
+		store := hooks.NewEventStore()
+		rotations := collect(store, dbresolver.EventCredentialsRotated)
+
+		writeCredentials("alice")
+
+		master := dbresolver.AsMaster(dbresolver.OpenRotating(factory, dbresolver.NewFileCredentialProvider(path)), "write")
+		master.SetMaxOpenConns(1)
+		defer master.Close()
+
+		db, err := dbresolver.New(dbresolver.DBConfig{
+			Master:      master,
+			DefaultMode: &dbresolver.DbWriteMode,
+		}, dbresolver.WithHooks(store))
+		require.NoError(t, err)
+
+		require.Equal(t, "alice.db", databaseFile(db))
+
+		// idle connections are reused until rotated
+		writeCredentials("bob")
+		require.Equal(t, "alice.db", databaseFile(db))
+
+		db.RotateCredentials(context.Background())
+		require.Equal(t, "bob.db", databaseFile(db))
+
+		require.Len(t, *rotations, 1)
+		require.Equal(t, []string{"write"}, (*rotations)[0].(dbresolver.CredentialsRotatedEvent).Nodes)
+	})
+
+	t.Run("recycles idle connections", func(t *testing.T) {
+		writeCredentials("alice")
+
+		var fetched int32
+		provider := dbresolver.CredentialProviderFunc(func(ctx context.Context) (dbresolver.Credentials, error) {
+			atomic.AddInt32(&fetched, 1)
+			return dbresolver.NewFileCredentialProvider(path).Credentials(ctx)
+		})
+
+		node := dbresolver.AsMaster(dbresolver.OpenRotating(factory, provider), "write")
+		node.SetMaxIdleConns(3)
+		defer node.Close()
+
+		conns := []*sql.Conn{}
+		for i := 0; i < 3; i++ {
+			conn, err := node.Conn(context.Background())
+			require.NoError(t, err)
+
+			conns = append(conns, conn)
+		}
+
+		for _, conn := range conns {
+			require.NoError(t, conn.Close())
+		}
+
+		require.Equal(t, 3, node.Stats().Idle)
+		require.Equal(t, int32(3), atomic.LoadInt32(&fetched))
+
+		writeCredentials("bob")
+		require.NoError(t, node.RotateCredentials())
+
+		// the retired idle connections were closed, and
+		// the ones left were opened with fresh credentials
+		fresh := int(atomic.LoadInt32(&fetched)) - 3
+		require.Positive(t, fresh)
+		require.Equal(t, fresh, node.Stats().OpenConnections)
+	})
+
+	t.Run("reload waits for the nodes being rotated", func(t *testing.T) {
+		writeCredentials("alice")
+
+		var blocking int32
+		waiting, unblock := make(chan struct{}, 1), make(chan struct{})
+		provider := dbresolver.CredentialProviderFunc(func(ctx context.Context) (dbresolver.Credentials, error) {
+			if atomic.LoadInt32(&blocking) == 1 {
+				waiting <- struct{}{}
+				<-unblock
+			}
+
+			return dbresolver.NewFileCredentialProvider(path).Credentials(ctx)
+		})
+
+		database, err := dbresolver.New(dbresolver.DBConfig{
+			Master: dbresolver.AsMaster(dbresolver.OpenRotating(factory, provider), "write"),
+		})
+		require.NoError(t, err)
+		require.NoError(t, database.Config.Master.Ping())
+
+		// recycling the idle connection opens a new one, which waits
+		atomic.StoreInt32(&blocking, 1)
+		writeCredentials("bob")
+
+		rotated := make(chan struct{})
+		go func() {
+			database.RotateCredentials(context.Background())
+			close(rotated)
+		}()
+
+		<-waiting
+
+		reloaded := make(chan error, 1)
+		go func() {
+			reloaded <- database.Reload(dbresolver.DBConfig{
+				Master: dbresolver.AsMaster(openDB(t, "./tmp/replica.db"), "write"),
+			})
+		}()
+
+		select {
+		case <-reloaded:
+			t.Fatal("reload returned before the rotation was done")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(unblock)
+		<-rotated
+		require.NoError(t, <-reloaded)
+	})
+
+	t.Run("provider errors fail the connection", func(t *testing.T) {
+		failing := dbresolver.CredentialProviderFunc(func(ctx context.Context) (dbresolver.Credentials, error) {
+			return dbresolver.Credentials{}, errors.New("vault unavailable")
+		})
+
+		node := dbresolver.AsMaster(dbresolver.OpenRotating(factory, failing), "write")
+		defer node.Close()
+
+		require.ErrorContains(t, node.Ping(), "vault unavailable")
+	})
+
+	t.Run("nodes opened with a dsn cannot rotate", func(t *testing.T) {
+		node := dbresolver.AsMaster(openDB(t, "./tmp/master.db"), "write")
+		require.ErrorIs(t, node.RotateCredentials(), dbresolver.ErrorNotRotating)
+	})
+}
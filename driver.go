@@ -0,0 +1,281 @@
+package dbresolver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// DriverName is the name the dbresolver driver is registered with.
+const DriverName = "dbresolver"
+
+var ErrorUnknownDSN = errors.New("no database registered for dsn")
+
+// ResolverDriver opens connections to the databases registered with
+// RegisterDSN. Each connection routes its statements through the
+// database, like the methods of Database do.
+type ResolverDriver struct {
+	mu        *sync.RWMutex
+	databases map[string]*Database
+}
+
+var resolverDriver = &ResolverDriver{mu: &sync.RWMutex{}, databases: map[string]*Database{}}
+
+func init() {
+	sql.Register(DriverName, resolverDriver)
+}
+
+// RegisterDSN makes the database available to sql.Open(DriverName, dsn).
+func RegisterDSN(dsn string, d *Database) {
+	resolverDriver.mu.Lock()
+	defer resolverDriver.mu.Unlock()
+
+	resolverDriver.databases[dsn] = d
+}
+
+func (rd *ResolverDriver) Open(dsn string) (driver.Conn, error) {
+	connector, err := rd.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return connector.Connect(context.Background())
+}
+
+func (rd *ResolverDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+
+	d, ok := rd.databases[dsn]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrorUnknownDSN, dsn)
+	}
+
+	return Connector(d), nil
+}
+
+// Connector lets the database be used as a plain *sql.DB:
+//
+//	db := sql.OpenDB(dbresolver.Connector(database))
+//
+// Statements go through the interceptors, hooks and koalescer, and are
+// routed by the classifier: reads follow the database mode, and writes
+// always run on master, whatever the mode. Transactions stay on the
+// node they were started on. Closing the *sql.DB leaves database open.
+func Connector(d *Database) driver.Connector {
+	return &connector{db: d}
+}
+
+type connector struct {
+	db *Database
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{db: c.db}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return resolverDriver
+}
+
+// conn holds no connection of its own, nodes are picked per
+// statement. It is pinned to a node while in a transaction.
+type conn struct {
+	db *Database
+	tx *Tx
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.IsolationLevel(opts.Isolation),
+		ReadOnly:  opts.ReadOnly,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.tx = tx
+	return &connTx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := namedValues(args)
+
+	if c.tx != nil {
+		return c.tx.ExecContext(ctx, query, values...)
+	}
+
+	db := c.db
+	if isDML(strings.ToLower(query)) {
+		db = db.WithMode(DbWriteMode)
+	}
+
+	return db.ExecContext(ctx, query, values...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := namedValues(args)
+
+	var result *ResultSet
+	var err error
+
+	if c.tx != nil {
+		result, err = c.tx.QueryResultSetContext(ctx, query, values...)
+	} else {
+		result, err = c.db.QueryResultSetContext(ctx, query, values...)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &resultRows{result: result}, nil
+}
+
+// Ping checks master, the node writes depend on
+func (c *conn) Ping(ctx context.Context) error {
+	master := c.db.acquireMaster()
+	defer master.release()
+
+	return master.PingContext(ctx)
+}
+
+// CheckNamedValue accepts every value, the driver of the node converts them
+func (c *conn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+type connTx struct {
+	conn *conn
+}
+
+func (t *connTx) Commit() error {
+	tx := t.conn.tx
+	t.conn.tx = nil
+
+	return tx.Commit()
+}
+
+func (t *connTx) Rollback() error {
+	tx := t.conn.tx
+	t.conn.tx = nil
+
+	return tx.Rollback()
+}
+
+// stmt is prepared lazily, the statement is sent with each call
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, valuesToNamed(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, valuesToNamed(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func namedValues(args []driver.NamedValue) []interface{} {
+	values := make([]interface{}, 0, len(args))
+
+	for _, arg := range args {
+		if arg.Name != "" {
+			values = append(values, sql.Named(arg.Name, arg.Value))
+			continue
+		}
+
+		values = append(values, arg.Value)
+	}
+
+	return values
+}
+
+func valuesToNamed(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, 0, len(args))
+	for i, arg := range args {
+		named = append(named, driver.NamedValue{Ordinal: i + 1, Value: arg})
+	}
+
+	return named
+}
+
+// resultRows reads a result set, which may be shared, for the driver
+type resultRows struct {
+	result *ResultSet
+	next   int
+}
+
+// Columns names the columns column1, column2, ... for rows made
+// by an interceptor without columns, as database/sql needs one
+// name per value.
+func (r *resultRows) Columns() []string {
+	if len(r.result.Columns) > 0 || len(r.result.Rows) == 0 {
+		return r.result.Columns.Names()
+	}
+
+	names := make([]string, len(*r.result.Rows[0]))
+	for i := range names {
+		names[i] = fmt.Sprintf("column%d", i+1)
+	}
+
+	return names
+}
+
+func (r *resultRows) Close() error {
+	return nil
+}
+
+func (r *resultRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.Rows) {
+		return io.EOF
+	}
+
+	// rows made by an interceptor may differ in width
+	for i, value := range *r.result.Rows[r.next] {
+		if i < len(dest) {
+			dest[i] = value
+		}
+	}
+
+	r.next++
+	return nil
+}
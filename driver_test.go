@@ -0,0 +1,151 @@
+package dbresolver_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver(t *testing.T) {
+	store := hooks.NewEventStore()
+	database := setupEventsDB(t, dbresolver.WithHooks(store))
+
+	db := sql.OpenDB(dbresolver.Connector(database))
+	defer db.Close()
+
+	t.Run("routes writes to master and reads to replicas", func(t *testing.T) {
+		selected := collect(store, dbresolver.EventAfterDBSelect)
+		defer store.Off(dbresolver.EventAfterDBSelect)
+
+		result, err := db.Exec(`INSERT INTO events (name) VALUES (?)`, "created")
+		require.NoError(t, err)
+
+		id, err := result.LastInsertId()
+		require.NoError(t, err)
+
+		var name string
+		require.NoError(t, db.QueryRow(`SELECT name FROM events WHERE id = ?`, id).Scan(&name))
+		require.Equal(t, "created", name)
+
+		require.Len(t, *selected, 2)
+		require.Equal(t, "events_write", (*selected)[0].(dbresolver.AfterDBSelectEvent).Name)
+		require.Equal(t, "events_read", (*selected)[1].(dbresolver.AfterDBSelectEvent).Name)
+	})
+
+	t.Run("exposes columns and named args", func(t *testing.T) {
+		rows, err := db.Query(`SELECT id, name FROM events WHERE name = :name`, sql.Named("name", "created"))
+		require.NoError(t, err)
+		defer rows.Close()
+
+		columns, err := rows.Columns()
+		require.NoError(t, err)
+		require.Equal(t, []string{"id", "name"}, columns)
+
+		count := 0
+		for rows.Next() {
+			var id int64
+			var name string
+
+			require.NoError(t, rows.Scan(&id, &name))
+			count++
+		}
+
+		require.NoError(t, rows.Err())
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("transactions stay on master", func(t *testing.T) {
+		after := collect(store, dbresolver.EventAfterQueryRun)
+		defer store.Off(dbresolver.EventAfterQueryRun)
+
+		tx, err := db.Begin()
+		require.NoError(t, err)
+
+		_, err = tx.Exec(`INSERT INTO events (name) VALUES (?)`, "in_tx")
+		require.NoError(t, err)
+
+		var count int
+		require.NoError(t, tx.QueryRow(`SELECT COUNT(*) FROM events`).Scan(&count))
+		require.Equal(t, 2, count)
+
+		require.NoError(t, tx.Rollback())
+
+		for _, payload := range *after {
+			require.Equal(t, "events_write", payload.(dbresolver.AfterQueryEvent).Node)
+		}
+
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM events`).Scan(&count))
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("opens registered dsns", func(t *testing.T) {
+		dbresolver.RegisterDSN("events", database)
+
+		named, err := sql.Open(dbresolver.DriverName, "events")
+		require.NoError(t, err)
+		defer named.Close()
+
+		require.NoError(t, named.Ping())
+
+		_, err = sql.Open(dbresolver.DriverName, "unknown")
+		require.ErrorIs(t, err, dbresolver.ErrorUnknownDSN)
+	})
+
+	t.Run("shares the koalescer with Database", func(t *testing.T) {
+		cached := setupEventsDB(t, dbresolver.WithQueryQualescer(
+			dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour)),
+		))
+
+		db := sql.OpenDB(dbresolver.Connector(cached))
+		defer db.Close()
+
+		_, err := cached.Query(`SELECT COUNT(*) FROM events`)
+		require.NoError(t, err)
+
+		var count int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM events`).Scan(&count))
+		require.Equal(t, 0, count)
+	})
+
+	t.Run("scans rows made by interceptors", func(t *testing.T) {
+		intercepted := setupEventsDB(t, dbresolver.WithInterceptors(
+			func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				return dbresolver.QueryResult{Rows: dbresolver.Rows{{int64(1), "cached"}}}, nil
+			},
+		))
+
+		db := sql.OpenDB(dbresolver.Connector(intercepted))
+		defer db.Close()
+
+		rows, err := db.Query(`SELECT id, name FROM events`)
+		require.NoError(t, err)
+		defer rows.Close()
+
+		columns, err := rows.Columns()
+		require.NoError(t, err)
+		require.Equal(t, []string{"column1", "column2"}, columns)
+
+		var (
+			id   int
+			name string
+		)
+
+		require.True(t, rows.Next())
+		require.NoError(t, rows.Scan(&id, &name))
+		require.Equal(t, 1, id)
+		require.Equal(t, "cached", name)
+	})
+
+	t.Run("pings master without selection events", func(t *testing.T) {
+		selected := collect(store, dbresolver.EventAfterDBSelect)
+		defer store.Off(dbresolver.EventAfterDBSelect)
+
+		require.NoError(t, db.PingContext(context.Background()))
+		require.Empty(t, *selected)
+	})
+}
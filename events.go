@@ -0,0 +1,80 @@
+package dbresolver
+
+import "time"
+
+// Events emitted on Database.Hooks. Each event carries
+// the payload type documented next to it.
+var (
+	EventBeforeDBSelect string = "before::select_db" // BeforeDBSelectEvent
+	EventAfterDBSelect  string = "after::select_db"  // AfterDBSelectEvent
+	EventBeforeQueryRun string = "before::query_run" // BeforeQueryEvent
+	EventAfterQueryRun  string = "after::query_run"  // AfterQueryEvent
+	EventQueryError     string = "query_error"       // AfterQueryEvent
+
+	EventKoalesceRefreshFailed string = "koalesce::refresh_failed" // KoalesceRefreshFailedEvent
+
+	EventTopologyReload string = "topology::reload" // TopologyReloadEvent
+
+	EventCredentialsRotated string = "credentials::rotated" // CredentialsRotatedEvent
+)
+
+type NodeRole string
+
+var (
+	RoleMaster  NodeRole = "master"
+	RoleReplica NodeRole = "replica"
+)
+
+type BeforeDBSelectEvent struct {
+	Mode DbActionMode
+}
+
+type AfterDBSelectEvent struct {
+	Role NodeRole
+	Name string
+	Zone string
+	// Index is the balancer index for replicas, and 0 for master
+	Index int64
+}
+
+type BeforeQueryEvent struct {
+	Operation Operation
+	Statement string
+	Args      []interface{}
+	// Prepared is set for the calls of a Stmt
+	Prepared bool
+}
+
+// AfterQueryEvent is emitted once a statement has finished.
+// Failed statements emit it on both EventAfterQueryRun and
+// EventQueryError, except for sql.ErrNoRows, which is only
+// set on the EventAfterQueryRun one.
+type AfterQueryEvent struct {
+	Operation Operation
+	Statement string
+	Args      []interface{}
+
+	// Node is empty when the statement was rejected before reaching a node
+	Node string
+	Role NodeRole
+
+	Duration time.Duration
+	// Rows is the number of rows returned by a query,
+	// or affected by an exec
+	Rows int64
+	Err  error
+	// Coalesced is true when the result was shared
+	// with another caller, or served from cache
+	Coalesced bool
+	// Prepared is set for the calls of a Stmt
+	Prepared bool
+}
+
+type KoalesceRefreshFailedEvent struct {
+	Key string
+	Err error
+}
+
+type CredentialsRotatedEvent struct {
+	Nodes []string
+}
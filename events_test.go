@@ -0,0 +1,162 @@
+package dbresolver_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func setupEventsDB(t *testing.T, opts ...dbresolver.DataBaseOpts) *dbresolver.Database {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "./tmp/events.db")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS events (id INTEGER PRIMARY KEY, name TEXT); DELETE FROM events;`)
+	require.NoError(t, err)
+
+	return dbresolver.Register(dbresolver.DBConfig{
+		Master:   dbresolver.AsMaster(db, "events_write"),
+		Replicas: []*dbresolver.ResolverDB{dbresolver.AsSyncReplica(db, "events_read")},
+	}, opts...)
+}
+
+func collect(store *hooks.EventStore, event string) *[]interface{} {
+	payloads := &[]interface{}{}
+
+	store.On(event, func(payload interface{}) hooks.Result {
+		*payloads = append(*payloads, payload)
+		return hooks.Result{}
+	})
+
+	return payloads
+}
+
+func TestQueryEvents(t *testing.T) {
+	store := hooks.NewEventStore()
+	database := setupEventsDB(t, dbresolver.WithHooks(store))
+
+	before := collect(store, dbresolver.EventBeforeQueryRun)
+	selected := collect(store, dbresolver.EventAfterDBSelect)
+	after := collect(store, dbresolver.EventAfterQueryRun)
+	failed := collect(store, dbresolver.EventQueryError)
+
+	t.Run("exec", func(t *testing.T) {
+		_, err := database.WithMode(dbresolver.DbWriteMode).Exec(`INSERT INTO events (name) VALUES (?), (?)`, "a", "b")
+		require.NoError(t, err)
+
+		require.Equal(t, dbresolver.BeforeQueryEvent{
+			Operation: dbresolver.OpExec,
+			Statement: `INSERT INTO events (name) VALUES (?), (?)`,
+			Args:      []interface{}{"a", "b"},
+		}, (*before)[0])
+
+		event := (*after)[0].(dbresolver.AfterQueryEvent)
+		require.Equal(t, "events_write", event.Node)
+		require.Equal(t, dbresolver.RoleMaster, event.Role)
+		require.Equal(t, int64(2), event.Rows)
+		require.NoError(t, event.Err)
+		require.Empty(t, *failed)
+	})
+
+	t.Run("query", func(t *testing.T) {
+		*selected, *after = nil, nil
+
+		rows, err := database.Query(`SELECT name FROM events`)
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+
+		require.Equal(t, dbresolver.AfterDBSelectEvent{
+			Role:  dbresolver.RoleReplica,
+			Name:  "events_read",
+			Index: 0,
+		}, (*selected)[0])
+
+		event := (*after)[0].(dbresolver.AfterQueryEvent)
+		require.Equal(t, "events_read", event.Node)
+		require.Equal(t, dbresolver.RoleReplica, event.Role)
+		require.Equal(t, int64(2), event.Rows)
+		require.False(t, event.Coalesced)
+		require.Greater(t, int64(event.Duration), int64(0))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		*after = nil
+
+		_, err := database.Query(`SELECT missing FROM events`)
+		require.Error(t, err)
+
+		_, err = database.Exec(`DELETE FROM events`)
+		require.ErrorIs(t, err, dbresolver.ErrorInvalidDBMode)
+
+		require.Len(t, *after, 2)
+		require.Len(t, *failed, 2)
+
+		event := (*failed)[1].(dbresolver.AfterQueryEvent)
+		require.Empty(t, event.Node)
+		require.ErrorIs(t, event.Err, dbresolver.ErrorInvalidDBMode)
+	})
+}
+
+func TestQueryEvents_Coalesced(t *testing.T) {
+	store := hooks.NewEventStore()
+	koalescer := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour))
+	database := setupEventsDB(t, dbresolver.WithHooks(store), dbresolver.WithQueryQualescer(koalescer))
+
+	after := collect(store, dbresolver.EventAfterQueryRun)
+
+	_, err := database.Query(`SELECT name FROM events`)
+	require.NoError(t, err)
+
+	_, err = database.Query(`SELECT name FROM events`)
+	require.NoError(t, err)
+
+	require.False(t, (*after)[0].(dbresolver.AfterQueryEvent).Coalesced)
+	require.True(t, (*after)[1].(dbresolver.AfterQueryEvent).Coalesced)
+	require.Equal(t, "events_read", (*after)[1].(dbresolver.AfterQueryEvent).Node)
+}
+
+type requestIDKey struct{}
+
+func TestQueryEvents_Context(t *testing.T) {
+	store := hooks.NewEventStore()
+	database := setupEventsDB(t, dbresolver.WithHooks(store))
+
+	requestIDs := map[string]interface{}{}
+	for _, event := range []string{
+		dbresolver.EventBeforeQueryRun,
+		dbresolver.EventBeforeDBSelect,
+		dbresolver.EventAfterDBSelect,
+		dbresolver.EventAfterQueryRun,
+	} {
+		event := event
+
+		store.OnContext(event, func(ctx context.Context, payload interface{}) hooks.Result {
+			requestIDs[event] = ctx.Value(requestIDKey{})
+			return hooks.Result{}
+		})
+	}
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "request-1")
+
+	_, err := database.QueryContext(ctx, `SELECT name FROM events`)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]interface{}{
+		dbresolver.EventBeforeQueryRun: "request-1",
+		dbresolver.EventBeforeDBSelect: "request-1",
+		dbresolver.EventAfterDBSelect:  "request-1",
+		dbresolver.EventAfterQueryRun:  "request-1",
+	}, requestIDs)
+
+	_, err = database.Query(`SELECT name FROM events`)
+	require.NoError(t, err)
+	require.Nil(t, requestIDs[dbresolver.EventAfterQueryRun])
+}
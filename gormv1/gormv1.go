@@ -0,0 +1,149 @@
+// Package gormv1 runs jinzhu/gorm v1 on a dbresolver database.
+//
+// Queries and row queries go to the replicas picked by the balancer,
+// creates, updates and deletes to master. Setting ModeKey on a query
+// overrides its route, e.g to read data just written:
+//
+//	db, err := gormv1.Open("postgres", database)
+//
+//	db.Set(gormv1.ModeKey, dbresolver.DbWriteMode).First(&user)
+//
+// gorm v1 sends its SQL through a single *sql.DB, so the route is passed
+// as a comment prepended to the query, and stripped before the statement
+// reaches the resolver. Statements of a gorm transaction carry no hint,
+// and run on master with it, like Database.BeginTx.
+package gormv1
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/jinzhu/gorm"
+)
+
+// ModeKey is the gorm setting overriding the route of a query,
+// set to a dbresolver.DbActionMode.
+const ModeKey = "dbresolver:mode"
+
+// Route hints are prepended to queries by the callbacks, and
+// stripped by the router before the statement reaches the resolver.
+const (
+	readHint  = "/* dbresolver:read */ "
+	writeHint = "/* dbresolver:write */ "
+)
+
+// Open opens a *gorm.DB on database, with the callbacks registered.
+// Closing it leaves database open.
+func Open(dialect string, database *dbresolver.Database) (*gorm.DB, error) {
+	db, err := gorm.Open(dialect, newRouter(database))
+	if err != nil {
+		return nil, err
+	}
+
+	Register(db)
+	return db, nil
+}
+
+// Register registers the callbacks honouring ModeKey on db,
+// which must be opened with Open.
+func Register(db *gorm.DB) {
+	db.Callback().Query().Before("gorm:query").Register("dbresolver:route", route)
+	db.Callback().RowQuery().Before("gorm:row_query").Register("dbresolver:route", route)
+}
+
+// route adds the hint for the mode set on the scope. Transactions
+// are left alone, they stay on the node they were started on.
+func route(scope *gorm.Scope) {
+	value, ok := scope.Get(ModeKey)
+	if !ok {
+		return
+	}
+
+	if _, ok := scope.SQLDB().(*router); !ok {
+		return
+	}
+
+	var hint string
+
+	switch dbresolver.DbActionMode(fmt.Sprint(value)) {
+	case dbresolver.DbReadMode:
+		hint = readHint
+	case dbresolver.DbWriteMode:
+		hint = writeHint
+	default:
+		scope.Err(fmt.Errorf("%w: %v", dbresolver.ErrorUnknownMode, value))
+		return
+	}
+
+	if existing, ok := scope.Get("gorm:query_hint"); ok {
+		hint += fmt.Sprint(existing)
+	}
+
+	scope.Set("gorm:query_hint", hint)
+}
+
+// router is the gorm.SQLCommon of the *gorm.DB. Each *sql.DB is
+// opened with dbresolver.Connector, in the mode of its route.
+type router struct {
+	db    *sql.DB
+	read  *sql.DB
+	write *sql.DB
+}
+
+func newRouter(database *dbresolver.Database) *router {
+	return &router{
+		db:    sql.OpenDB(dbresolver.Connector(database)),
+		read:  sql.OpenDB(dbresolver.Connector(database.WithMode(dbresolver.DbReadMode))),
+		write: sql.OpenDB(dbresolver.Connector(database.WithMode(dbresolver.DbWriteMode))),
+	}
+}
+
+// pick strips the route hint of query, and returns the *sql.DB to run it on
+func (r *router) pick(query string) (*sql.DB, string) {
+	switch {
+	case strings.HasPrefix(query, readHint):
+		return r.read, strings.TrimPrefix(query, readHint)
+	case strings.HasPrefix(query, writeHint):
+		return r.write, strings.TrimPrefix(query, writeHint)
+	default:
+		return r.db, query
+	}
+}
+
+func (r *router) Exec(query string, args ...interface{}) (sql.Result, error) {
+	db, query := r.pick(query)
+	return db.Exec(query, args...)
+}
+
+func (r *router) Prepare(query string) (*sql.Stmt, error) {
+	db, query := r.pick(query)
+	return db.Prepare(query)
+}
+
+func (r *router) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	db, query := r.pick(query)
+	return db.Query(query, args...)
+}
+
+func (r *router) QueryRow(query string, args ...interface{}) *sql.Row {
+	db, query := r.pick(query)
+	return db.QueryRow(query, args...)
+}
+
+func (r *router) Begin() (*sql.Tx, error) {
+	return r.db.Begin()
+}
+
+func (r *router) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return r.db.BeginTx(ctx, opts)
+}
+
+func (r *router) Close() error {
+	r.read.Close()
+	r.write.Close()
+
+	return r.db.Close()
+}
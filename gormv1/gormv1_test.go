@@ -0,0 +1,154 @@
+package gormv1_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/gormv1"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+type User struct {
+	ID   uint
+	Name string
+}
+
+// sqliteNode opens a sqlite file with an empty users table, created
+// by gorm from User. The gorm handle is not closed, it would close db.
+func sqliteNode(t *testing.T, path string) (*sql.DB, *gorm.DB) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	direct, err := gorm.Open("sqlite3", db)
+	require.NoError(t, err)
+	require.NoError(t, direct.DropTableIfExists(&User{}).AutoMigrate(&User{}).Error)
+
+	return db, direct
+}
+
+// openGorm opens gorm on a master and a replica which already holds
+// a user master lacks, so that finding it tells the replica answered.
+// It returns the nodes each statement ran on, from the hooks.
+func openGorm(t *testing.T) (*gorm.DB, *sql.DB, *sql.DB, *[]string) {
+	t.Helper()
+
+	master, _ := sqliteNode(t, "../tmp/gorm_write.db")
+	replica, seed := sqliteNode(t, "../tmp/gorm_read.db")
+
+	require.NoError(t, seed.Create(&User{Name: "replicated"}).Error)
+
+	nodes := &[]string{}
+
+	store := hooks.NewEventStore()
+	store.On(dbresolver.EventAfterQueryRun, func(payload interface{}) hooks.Result {
+		*nodes = append(*nodes, payload.(dbresolver.AfterQueryEvent).Node)
+		return hooks.Result{}
+	})
+
+	database, err := dbresolver.New(dbresolver.DBConfig{
+		Master:   dbresolver.AsMaster(master, "write"),
+		Replicas: []*dbresolver.ResolverDB{dbresolver.AsReplica(replica, "read")},
+	}, dbresolver.WithHooks(store))
+	require.NoError(t, err)
+
+	db, err := gormv1.Open("sqlite3", database)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db, master, replica, nodes
+}
+
+func count(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	var n int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n))
+
+	return n
+}
+
+func TestGorm(t *testing.T) {
+	t.Run("writes go to master and reads to replicas", func(t *testing.T) {
+		db, master, replica, nodes := openGorm(t)
+
+		user := User{Name: "jane"}
+		require.NoError(t, db.Create(&user).Error)
+		require.NotZero(t, user.ID)
+
+		require.NoError(t, db.Model(&user).Update("name", "janet").Error)
+
+		require.Equal(t, 1, count(t, master))
+		require.Equal(t, 1, count(t, replica))
+
+		users := []User{}
+		require.NoError(t, db.Find(&users).Error)
+		require.Len(t, users, 1)
+		require.Equal(t, "replicated", users[0].Name)
+
+		var name string
+		require.NoError(t, db.Table("users").Select("name").Row().Scan(&name))
+		require.Equal(t, "replicated", name)
+
+		require.NoError(t, db.Delete(&User{}, user.ID).Error)
+		require.Equal(t, 0, count(t, master))
+
+		require.Contains(t, *nodes, "write")
+		require.Contains(t, *nodes, "read")
+	})
+
+	t.Run("the mode setting overrides the route", func(t *testing.T) {
+		db, _, _, nodes := openGorm(t)
+
+		require.NoError(t, db.Create(&User{Name: "jane"}).Error)
+
+		*nodes = (*nodes)[:0]
+
+		user := User{}
+		require.NoError(t, db.Set(gormv1.ModeKey, dbresolver.DbWriteMode).First(&user).Error)
+		require.Equal(t, "jane", user.Name)
+
+		rows, err := db.Set(gormv1.ModeKey, dbresolver.DbWriteMode).Table("users").Select("name").Rows()
+		require.NoError(t, err)
+		require.True(t, rows.Next())
+		require.NoError(t, rows.Scan(&user.Name))
+		require.NoError(t, rows.Close())
+		require.Equal(t, "jane", user.Name)
+
+		require.Equal(t, []string{"write", "write"}, *nodes)
+
+		// the setting is scoped to the query
+		require.NoError(t, db.First(&user).Error)
+		require.Equal(t, "replicated", user.Name)
+
+		err = db.Set(gormv1.ModeKey, "primary").First(&user).Error
+		require.ErrorIs(t, err, dbresolver.ErrorUnknownMode)
+	})
+
+	t.Run("transactions stay on master", func(t *testing.T) {
+		db, master, _, nodes := openGorm(t)
+
+		tx := db.Begin()
+		require.NoError(t, tx.Error)
+
+		require.NoError(t, tx.Create(&User{Name: "jane"}).Error)
+
+		users := []User{}
+		require.NoError(t, tx.Find(&users).Error)
+		require.Len(t, users, 1)
+		require.Equal(t, "jane", users[0].Name)
+
+		require.NoError(t, tx.Commit().Error)
+		require.Equal(t, 1, count(t, master))
+
+		for _, node := range *nodes {
+			require.Equal(t, "write", node)
+		}
+	})
+}
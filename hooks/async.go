@@ -0,0 +1,177 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-batteries/dbresolver/internal/detached"
+)
+
+var (
+	ErrEventDropped = errors.New("event_dropped")
+	ErrStoreClosed  = errors.New("store_closed")
+)
+
+// OverflowPolicy decides what happens to an event emitted
+// while the queue of an AsyncEventStore is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop drops the event.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock waits for room in the queue.
+	OverflowBlock
+	// OverflowSample starts dropping early: once the queue is half full,
+	// only one in SampleEvery events is queued. A full queue drops.
+	OverflowSample
+)
+
+const (
+	DefaultQueueSize   = 1024
+	DefaultSampleEvery = 10
+)
+
+type AsyncOpts struct {
+	QueueSize   int
+	Workers     int
+	Overflow    OverflowPolicy
+	SampleEvery int
+}
+
+type queuedEvent struct {
+	ctx     context.Context
+	name    string
+	payload interface{}
+}
+
+// AsyncEventStore runs handlers on a pool of workers, so that Emit
+// never waits on them. Handlers are registered as on an EventStore.
+// Emit returns an empty Result once the event is queued, since the
+// handlers have not run yet.
+type AsyncEventStore struct {
+	// the counters come first to stay 64 bit aligned
+	emitted uint64
+	dropped uint64
+
+	*EventStore
+
+	opts  AsyncOpts
+	queue chan queuedEvent
+	wg    *sync.WaitGroup
+
+	mu     *sync.RWMutex
+	closed bool
+}
+
+// NewAsyncEventStore dispatches the events of store asynchronously.
+// When store is nil, a new EventStore is used.
+func NewAsyncEventStore(store *EventStore, opts AsyncOpts) *AsyncEventStore {
+	if store == nil {
+		store = NewEventStore()
+	}
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	if opts.SampleEvery <= 0 {
+		opts.SampleEvery = DefaultSampleEvery
+	}
+
+	async := &AsyncEventStore{
+		EventStore: store,
+		opts:       opts,
+		queue:      make(chan queuedEvent, opts.QueueSize),
+		wg:         &sync.WaitGroup{},
+		mu:         &sync.RWMutex{},
+	}
+
+	async.wg.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go async.work()
+	}
+
+	return async
+}
+
+func (a *AsyncEventStore) work() {
+	defer a.wg.Done()
+
+	for event := range a.queue {
+		a.EventStore.EmitContext(event.ctx, event.name, event.payload)
+	}
+}
+
+func (a *AsyncEventStore) Emit(event string, payload interface{}) Result {
+	return a.EmitContext(context.Background(), event, payload)
+}
+
+// EmitContext queues the event. Handlers receive a context with the
+// values of ctx, which is not cancelled when ctx is.
+func (a *AsyncEventStore) EmitContext(ctx context.Context, event string, payload interface{}) Result {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return Result{Err: ErrStoreClosed}
+	}
+
+	queued := queuedEvent{
+		ctx:     detached.Context(ctx),
+		name:    event,
+		payload: payload,
+	}
+
+	count := atomic.AddUint64(&a.emitted, 1)
+
+	if a.opts.Overflow == OverflowBlock {
+		a.queue <- queued
+		return Result{}
+	}
+
+	if a.opts.Overflow == OverflowSample && len(a.queue) >= cap(a.queue)/2 && count%uint64(a.opts.SampleEvery) != 0 {
+		atomic.AddUint64(&a.dropped, 1)
+		return Result{Err: ErrEventDropped}
+	}
+
+	select {
+	case a.queue <- queued:
+		return Result{}
+	default:
+		atomic.AddUint64(&a.dropped, 1)
+		return Result{Err: ErrEventDropped}
+	}
+}
+
+// Close stops accepting events, and returns once
+// every queued event has been handled.
+func (a *AsyncEventStore) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	a.wg.Wait()
+	return nil
+}
+
+// Dropped is the number of events dropped by the overflow policy.
+func (a *AsyncEventStore) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Pending is the number of events waiting for a worker.
+func (a *AsyncEventStore) Pending() int {
+	return len(a.queue)
+}
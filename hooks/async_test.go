@@ -0,0 +1,129 @@
+package hooks
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	timeout = time.Second
+	tick    = time.Millisecond
+)
+
+func TestAsyncEventStore(t *testing.T) {
+	t.Run("handlers run off the emitting goroutine and flush on close", func(t *testing.T) {
+		async := NewAsyncEventStore(nil, AsyncOpts{Workers: 4})
+
+		var handled int32
+		async.On("event", func(payload interface{}) Result {
+			atomic.AddInt32(&handled, 1)
+			return Result{}
+		})
+
+		for i := 0; i < 100; i++ {
+			require.NoError(t, async.Emit("event", i).Err)
+		}
+
+		require.NoError(t, async.Close())
+		require.Equal(t, int32(100), atomic.LoadInt32(&handled))
+		require.Equal(t, ErrStoreClosed, async.Emit("event", nil).Err)
+	})
+
+	t.Run("context values reach handlers after cancellation", func(t *testing.T) {
+		async := NewAsyncEventStore(nil, AsyncOpts{})
+
+		got := make(chan interface{}, 1)
+		async.OnContext("event", func(ctx context.Context, payload interface{}) Result {
+			got <- []interface{}{ctx.Value(ctxKey{}), ctx.Err()}
+			return Result{}
+		})
+
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "request-1"))
+		async.EmitContext(ctx, "event", nil)
+		cancel()
+
+		require.NoError(t, async.Close())
+		require.Equal(t, []interface{}{"request-1", nil}, <-got)
+	})
+
+	blockingStore := func(opts AsyncOpts) (*AsyncEventStore, chan struct{}, *int32) {
+		async := NewAsyncEventStore(nil, opts)
+		block := make(chan struct{})
+
+		var handled int32
+		async.On("event", func(payload interface{}) Result {
+			<-block
+			atomic.AddInt32(&handled, 1)
+			return Result{}
+		})
+
+		return async, block, &handled
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		async, block, handled := blockingStore(AsyncOpts{QueueSize: 2, Overflow: OverflowDrop})
+
+		// The first event is taken by the worker and blocks it
+		async.Emit("event", nil)
+		require.Eventually(t, func() bool { return async.Pending() == 0 }, timeout, tick)
+
+		results := []error{}
+		for i := 0; i < 4; i++ {
+			results = append(results, async.Emit("event", nil).Err)
+		}
+
+		require.Equal(t, []error{nil, nil, ErrEventDropped, ErrEventDropped}, results)
+		require.Equal(t, uint64(2), async.Dropped())
+
+		close(block)
+		require.NoError(t, async.Close())
+		require.Equal(t, int32(3), atomic.LoadInt32(handled))
+	})
+
+	t.Run("block", func(t *testing.T) {
+		async, block, handled := blockingStore(AsyncOpts{QueueSize: 1, Overflow: OverflowBlock})
+
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 3; i++ {
+				async.Emit("event", nil)
+			}
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("emit should block while the queue is full")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		close(block)
+		<-done
+
+		require.NoError(t, async.Close())
+		require.Equal(t, int32(3), atomic.LoadInt32(handled))
+		require.Zero(t, async.Dropped())
+	})
+
+	t.Run("sample", func(t *testing.T) {
+		async, block, _ := blockingStore(AsyncOpts{QueueSize: 4, Overflow: OverflowSample, SampleEvery: 2})
+
+		async.Emit("event", nil)
+		require.Eventually(t, func() bool { return async.Pending() == 0 }, timeout, tick)
+
+		// Below half full every event is queued, above it one in two
+		for i := 0; i < 6; i++ {
+			async.Emit("event", nil)
+		}
+
+		require.Equal(t, 4, async.Pending())
+		require.Equal(t, uint64(2), async.Dropped())
+
+		close(block)
+		require.NoError(t, async.Close())
+	})
+}
@@ -1,80 +1,215 @@
 package hooks
 
 import (
+	"context"
 	"errors"
+	"sort"
+	"strings"
 	"sync"
+
+	"github.com/go-batteries/dbresolver/internal/multierr"
 )
 
 var (
 	ErrUninitialized = errors.New("store_empty")
 )
 
+// Wildcard subscribes a handler to every event. A name ending
+// with it, like "before::*", subscribes to every event with that prefix.
+const Wildcard = "*"
+
+// Result is returned by a handler. When an event has more than one
+// handler, Emit aggregates their results:
+//
+//   - handlers run by descending priority, then in registration order
+//   - Data is the first non nil Data returned
+//   - Err is nil, the single error returned, or Errors with all of them
+//   - a handler returning Stop prevents the remaining handlers from running
 type Result struct {
 	Data interface{}
 	Err  error
+	Stop bool
+}
+
+// Errors collects the errors returned by the handlers of one event.
+type Errors []error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+func (errs Errors) Unwrap() []error {
+	return errs
 }
 
-type EventHandler func(args ...interface{}) Result
+// Is reports whether one of the errors matches target.
+func (errs Errors) Is(target error) bool {
+	return multierr.Is(errs, target)
+}
+
+// As finds the first of the errors matching target.
+func (errs Errors) As(target interface{}) bool {
+	return multierr.As(errs, target)
+}
+
+// EventHandler receives the payload passed to Emit. Emitters document
+// the payload type of each event, so handlers can type assert it.
+type EventHandler func(payload interface{}) Result
+
+// ContextEventHandler also receives the context passed to EmitContext,
+// or context.Background for Emit.
+type ContextEventHandler func(ctx context.Context, payload interface{}) Result
 
 type EventEmitter interface {
-	On(eventName string, handler EventHandler)
+	On(eventName string, handler EventHandler, opts ...SubscribeOpts) *Subscription
+	OnContext(eventName string, handler ContextEventHandler, opts ...SubscribeOpts) *Subscription
 	Off(eventName string)
-	Emit(eventName string, args ...interface{}) Result
+	Emit(eventName string, payload interface{}) Result
+	EmitContext(ctx context.Context, eventName string, payload interface{}) Result
 }
 
+// Event is a registered handler. Fn is set for handlers added
+// with On, and ContextFn for handlers added with OnContext.
 type Event struct {
+	Name      string
+	Fn        EventHandler
+	ContextFn ContextEventHandler
+	Priority  int
+
+	id uint64
+}
+
+func (event Event) call(ctx context.Context, payload interface{}) Result {
+	if event.ContextFn != nil {
+		return event.ContextFn(ctx, payload)
+	}
+
+	return event.Fn(payload)
+}
+
+type SubscribeOpts func(event *Event)
+
+// WithPriority runs the handler before handlers with a lower priority.
+// The default priority is 0.
+func WithPriority(priority int) SubscribeOpts {
+	return func(event *Event) {
+		event.Priority = priority
+	}
+}
+
+// Subscription is a handle to a single registered handler.
+type Subscription struct {
 	Name string
-	Fn   EventHandler
+
+	off func()
+}
+
+func NewSubscription(name string, off func()) *Subscription {
+	return &Subscription{Name: name, off: off}
+}
+
+// Off removes the handler. It is safe to call more than once.
+func (s *Subscription) Off() {
+	if s.off != nil {
+		s.off()
+	}
 }
 
 type EventStore struct {
 	mu        *sync.RWMutex
-	observers map[string]Event
+	lastID    uint64
+	observers map[string][]Event
 }
 
 func NewEventStore() *EventStore {
 	return &EventStore{
 		mu:        &sync.RWMutex{},
-		observers: make(map[string]Event),
+		observers: make(map[string][]Event),
 	}
 }
 
-func (e *EventStore) On(name string, fn EventHandler) {
-	var store *EventStore
+// On adds fn to the handlers of name. Earlier handlers are kept.
+func (e *EventStore) On(name string, fn EventHandler, opts ...SubscribeOpts) *Subscription {
+	return e.subscribe(Event{Name: name, Fn: fn}, opts)
+}
+
+// OnContext is On for handlers that need the context of the emitter.
+func (e *EventStore) OnContext(name string, fn ContextEventHandler, opts ...SubscribeOpts) *Subscription {
+	return e.subscribe(Event{Name: name, ContextFn: fn}, opts)
+}
+
+func (e *EventStore) subscribe(event Event, opts []SubscribeOpts) *Subscription {
+	name := event.Name
 
 	if e.observers == nil {
-		store = NewEventStore()
-	} else {
-		store = e
+		return NewSubscription(name, nil)
 	}
 
-	event := Event{
-		Name: name,
-		Fn:   fn,
+	for _, opt := range opts {
+		opt(&event)
 	}
 
-	store.mu.Lock()
-	defer store.mu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastID++
+	event.id = e.lastID
+	e.observers[name] = append(e.observers[name], event)
+
+	id := event.id
+	return NewSubscription(name, func() {
+		e.remove(name, id)
+	})
+}
 
-	store.observers[name] = event
+func (e *EventStore) Emit(event string, payload interface{}) Result {
+	return e.EmitContext(context.Background(), event, payload)
 }
 
-func (e *EventStore) Emit(event string, data ...interface{}) Result {
+func (e *EventStore) EmitContext(ctx context.Context, event string, payload interface{}) Result {
 	if e.observers == nil {
 		return Result{Err: ErrUninitialized}
 	}
 
-	e.mu.Lock()
-	handler, ok := e.observers[event]
-	if !ok {
-		e.mu.Unlock()
-		return Result{}
+	handlers := e.handlers(event)
+
+	var result Result
+	var errs Errors
+
+	for _, handler := range handlers {
+		res := handler.call(ctx, payload)
+
+		if result.Data == nil {
+			result.Data = res.Data
+		}
+
+		if res.Err != nil {
+			errs = append(errs, res.Err)
+		}
+
+		if res.Stop {
+			result.Stop = true
+			break
+		}
 	}
 
-	e.mu.Unlock()
-	return handler.Fn(data...)
+	switch len(errs) {
+	case 0:
+	case 1:
+		result.Err = errs[0]
+	default:
+		result.Err = errs
+	}
+
+	return result
 }
 
+// Off removes every handler registered with exactly this name.
 func (e *EventStore) Off(event string) {
 	if e.observers == nil {
 		return
@@ -83,8 +218,61 @@ func (e *EventStore) Off(event string) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	_, ok := e.observers[event]
-	if ok {
-		delete(e.observers, event)
+	delete(e.observers, event)
+}
+
+func (e *EventStore) remove(name string, id uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	events := e.observers[name]
+	for i, event := range events {
+		if event.id != id {
+			continue
+		}
+
+		events = append(events[:i:i], events[i+1:]...)
+		break
 	}
+
+	if len(events) == 0 {
+		delete(e.observers, name)
+		return
+	}
+
+	e.observers[name] = events
+}
+
+// handlers returns the handlers for event, including
+// wildcard subscriptions, in the order they should run.
+func (e *EventStore) handlers(event string) []Event {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var handlers []Event
+
+	for name, events := range e.observers {
+		if Matches(name, event) {
+			handlers = append(handlers, events...)
+		}
+	}
+
+	sort.Slice(handlers, func(i, j int) bool {
+		if handlers[i].Priority == handlers[j].Priority {
+			return handlers[i].id < handlers[j].id
+		}
+
+		return handlers[i].Priority > handlers[j].Priority
+	})
+
+	return handlers
+}
+
+// Matches reports whether a subscription to pattern receives event.
+func Matches(pattern, event string) bool {
+	if !strings.HasSuffix(pattern, Wildcard) {
+		return pattern == event
+	}
+
+	return strings.HasPrefix(event, strings.TrimSuffix(pattern, Wildcard))
 }
@@ -0,0 +1,191 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func recorder(calls *[]string, name string, res Result) EventHandler {
+	return func(payload interface{}) Result {
+		*calls = append(*calls, name)
+		return res
+	}
+}
+
+func TestEventStore_MultipleHandlers(t *testing.T) {
+	t.Run("handlers are kept and run in registration order", func(t *testing.T) {
+		store := NewEventStore()
+		calls := []string{}
+
+		store.On("before::query_run", recorder(&calls, "logging", Result{}))
+		store.On("before::query_run", recorder(&calls, "metrics", Result{}))
+
+		store.Emit("before::query_run", nil)
+		require.Equal(t, []string{"logging", "metrics"}, calls)
+	})
+
+	t.Run("higher priority runs first", func(t *testing.T) {
+		store := NewEventStore()
+		calls := []string{}
+
+		store.On("event", recorder(&calls, "low", Result{}), WithPriority(-1))
+		store.On("event", recorder(&calls, "default", Result{}))
+		store.On("event", recorder(&calls, "high", Result{}), WithPriority(10))
+
+		store.Emit("event", nil)
+		require.Equal(t, []string{"high", "default", "low"}, calls)
+	})
+
+	t.Run("subscription removes only its own handler", func(t *testing.T) {
+		store := NewEventStore()
+		calls := []string{}
+
+		first := store.On("event", recorder(&calls, "first", Result{}))
+		store.On("event", recorder(&calls, "second", Result{}))
+
+		first.Off()
+		first.Off()
+
+		store.Emit("event", nil)
+		require.Equal(t, []string{"second"}, calls)
+	})
+
+	t.Run("off removes every handler of the event", func(t *testing.T) {
+		store := NewEventStore()
+		calls := []string{}
+
+		store.On("event", recorder(&calls, "first", Result{}))
+		store.On("event", recorder(&calls, "second", Result{}))
+		store.Off("event")
+
+		store.Emit("event", nil)
+		require.Empty(t, calls)
+	})
+}
+
+func TestEventStore_Wildcards(t *testing.T) {
+	store := NewEventStore()
+	calls := []string{}
+
+	store.On("before::*", recorder(&calls, "before", Result{}))
+	store.On(Wildcard, recorder(&calls, "all", Result{}))
+	store.On("before::query_run", recorder(&calls, "exact", Result{}))
+
+	store.Emit("before::query_run", nil)
+	require.Equal(t, []string{"before", "all", "exact"}, calls)
+
+	calls = calls[:0]
+	store.Emit("after::query_run", nil)
+	require.Equal(t, []string{"all"}, calls)
+
+	require.True(t, Matches("before::*", "before::select_db"))
+	require.False(t, Matches("before::*", "after::select_db"))
+	require.False(t, Matches("before::query_run", "before::query_run_x"))
+}
+
+func TestEventStore_ResultAggregation(t *testing.T) {
+	errFirst := errors.New("first")
+	errSecond := errors.New("second")
+
+	t.Run("first non nil data wins", func(t *testing.T) {
+		store := NewEventStore()
+		calls := []string{}
+
+		store.On("event", recorder(&calls, "empty", Result{}))
+		store.On("event", recorder(&calls, "one", Result{Data: 1}))
+		store.On("event", recorder(&calls, "two", Result{Data: 2}))
+
+		res := store.Emit("event", nil)
+		require.Equal(t, 1, res.Data)
+		require.NoError(t, res.Err)
+	})
+
+	t.Run("single error is returned as is", func(t *testing.T) {
+		store := NewEventStore()
+		calls := []string{}
+
+		store.On("event", recorder(&calls, "one", Result{Err: errFirst}))
+		store.On("event", recorder(&calls, "two", Result{}))
+
+		res := store.Emit("event", nil)
+		require.Equal(t, errFirst, res.Err)
+	})
+
+	t.Run("multiple errors are collected", func(t *testing.T) {
+		store := NewEventStore()
+		calls := []string{}
+
+		store.On("event", recorder(&calls, "one", Result{Err: errFirst}))
+		store.On("event", recorder(&calls, "two", Result{Err: errSecond}))
+
+		res := store.Emit("event", nil)
+		require.Equal(t, Errors{errFirst, errSecond}, res.Err)
+		require.Equal(t, "first; second", res.Err.Error())
+
+		require.ErrorIs(t, res.Err, errFirst)
+		require.ErrorIs(t, res.Err, errSecond)
+		require.NotErrorIs(t, res.Err, ErrUninitialized)
+	})
+
+	t.Run("errors can be matched with errors.As", func(t *testing.T) {
+		store := NewEventStore()
+		calls := []string{}
+
+		pathErr := &fs.PathError{Op: "open", Path: "hooks.yaml", Err: fs.ErrNotExist}
+
+		store.On("event", recorder(&calls, "one", Result{Err: errFirst}))
+		store.On("event", recorder(&calls, "two", Result{Err: fmt.Errorf("load: %w", pathErr)}))
+
+		res := store.Emit("event", nil)
+
+		var target *fs.PathError
+		require.ErrorAs(t, res.Err, &target)
+		require.Equal(t, pathErr, target)
+		require.ErrorIs(t, res.Err, fs.ErrNotExist)
+	})
+
+	t.Run("stop skips the remaining handlers", func(t *testing.T) {
+		store := NewEventStore()
+		calls := []string{}
+
+		store.On("event", recorder(&calls, "one", Result{Stop: true}))
+		store.On("event", recorder(&calls, "two", Result{}))
+
+		res := store.Emit("event", nil)
+		require.True(t, res.Stop)
+		require.Equal(t, []string{"one"}, calls)
+	})
+
+	t.Run("uninitialized store", func(t *testing.T) {
+		store := &EventStore{}
+
+		require.Equal(t, ErrUninitialized, store.Emit("event", nil).Err)
+		store.On("event", recorder(&[]string{}, "one", Result{})).Off()
+	})
+}
+
+type ctxKey struct{}
+
+func TestEventStore_Context(t *testing.T) {
+	store := NewEventStore()
+	got := []interface{}{}
+
+	store.OnContext("event", func(ctx context.Context, payload interface{}) Result {
+		got = append(got, ctx.Value(ctxKey{}))
+		return Result{}
+	})
+	store.On("event", func(payload interface{}) Result {
+		got = append(got, payload)
+		return Result{}
+	})
+
+	store.EmitContext(context.WithValue(context.Background(), ctxKey{}, "request-1"), "event", "payload")
+	store.Emit("event", "payload")
+
+	require.Equal(t, []interface{}{"request-1", "payload", nil, "payload"}, got)
+}
@@ -0,0 +1,174 @@
+package dbresolver
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type Operation string
+
+var (
+	OpExec     Operation = "exec"
+	OpQuery    Operation = "query"
+	OpQueryRow Operation = "query_row"
+	OpBegin    Operation = "begin"
+	OpCommit   Operation = "commit"
+	OpRollback Operation = "rollback"
+
+	// OpQueryStream is the opening of a stream, its rows
+	// are read after the interceptors returned
+	OpQueryStream Operation = "query_stream"
+)
+
+// QueryInfo describes a call going through the interceptor chain.
+// Interceptors may change Statement and Args before calling next.
+// Node and Coalesced are filled in once the call reached a node.
+type QueryInfo struct {
+	Op        Operation
+	Statement string
+	Args      []interface{}
+	Mode      DbActionMode
+	InTx      bool
+	// Prepared is set for the calls of a Stmt. An interceptor rewriting
+	// the statement makes it run unprepared.
+	Prepared bool
+
+	Node      *ResolverDB
+	Coalesced bool
+	// Retries lists the failed attempts made by RetryInterceptor
+	Retries []RetryAttempt
+
+	stmt *Stmt
+}
+
+type RetryAttempt struct {
+	Attempt int
+	Node    *ResolverDB
+	Err     error
+	At      time.Time
+}
+
+// QueryResult holds the result of a call, depending on its Op:
+// Rows for OpQuery, Row for OpQueryRow, Result for OpExec and
+// Tx for OpBegin. Typed replaces Rows and Row for QueryAs and
+// QueryOneAs, holding the []T or T returned. Stream is the opened
+// stream for OpQueryStream.
+type QueryResult struct {
+	Rows   Rows
+	Row    *Row
+	Result sql.Result
+	Tx     *sql.Tx
+	Typed  interface{}
+	Stream *StreamRows
+}
+
+// RowCount is the number of rows returned,
+// or affected for OpExec.
+func (qr QueryResult) RowCount() int64 {
+	switch {
+	case qr.Rows != nil:
+		return int64(len(qr.Rows))
+	case qr.Row != nil:
+		return 1
+	case qr.Result != nil:
+		affected, _ := qr.Result.RowsAffected()
+		return affected
+	case qr.Typed != nil:
+		if typed := reflect.ValueOf(qr.Typed); typed.Kind() == reflect.Slice {
+			return int64(typed.Len())
+		}
+
+		return 1
+	}
+
+	return 0
+}
+
+type QueryFunc func(ctx context.Context, info *QueryInfo) (QueryResult, error)
+
+// Interceptor wraps every Exec, Query, QueryRow and transaction call.
+// It can rewrite the statement, veto the call by returning an error,
+// change ctx, call next more than once, or return a result without
+// calling next at all.
+type Interceptor func(ctx context.Context, info *QueryInfo, next QueryFunc) (QueryResult, error)
+
+// WithInterceptors appends interceptors to the chain.
+// The first interceptor is the outermost one.
+func WithInterceptors(interceptors ...Interceptor) DataBaseOpts {
+	return func(d *Database) {
+		d.interceptors = append(d.interceptors, interceptors...)
+	}
+}
+
+// ChainInterceptors composes interceptors into one,
+// with the first being the outermost.
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	return func(ctx context.Context, info *QueryInfo, next QueryFunc) (QueryResult, error) {
+		return chain(interceptors, next)(ctx, info)
+	}
+}
+
+func chain(interceptors []Interceptor, terminal QueryFunc) QueryFunc {
+	next := terminal
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := interceptors[i], next
+
+		next = func(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+			return interceptor(ctx, info, inner)
+		}
+	}
+
+	return next
+}
+
+// TimeoutInterceptor bounds every call with timeout, unless ctx already
+// has an earlier deadline. Transactions and streams are not bounded, since
+// the context passed to Begin or QueryStream lives as long as the
+// transaction, or the reading of the stream.
+func TimeoutInterceptor(timeout time.Duration) Interceptor {
+	return func(ctx context.Context, info *QueryInfo, next QueryFunc) (QueryResult, error) {
+		if info.Op == OpBegin || info.Op == OpQueryStream {
+			return next(ctx, info)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		return next(ctx, info)
+	}
+}
+
+// RetryInterceptor runs reads up to attempts times while retryable
+// returns true for the error. Every attempt goes through the balancer
+// again, so it usually lands on another replica. Writes and statements
+// inside a transaction are never retried.
+func RetryInterceptor(attempts int, retryable func(error) bool) Interceptor {
+	return func(ctx context.Context, info *QueryInfo, next QueryFunc) (QueryResult, error) {
+		if info.InTx || (info.Op != OpQuery && info.Op != OpQueryRow) || isDML(strings.ToLower(info.Statement)) {
+			return next(ctx, info)
+		}
+
+		var res QueryResult
+		var err error
+
+		for attempt := 1; attempt <= attempts; attempt++ {
+			res, err = next(ctx, info)
+			if err == nil || !retryable(err) || ctx.Err() != nil || attempt == attempts {
+				break
+			}
+
+			info.Retries = append(info.Retries, RetryAttempt{
+				Attempt: attempt,
+				Node:    info.Node,
+				Err:     err,
+				At:      time.Now(),
+			})
+		}
+
+		return res, err
+	}
+}
@@ -0,0 +1,186 @@
+package dbresolver_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptors(t *testing.T) {
+	t.Run("run in order around every call", func(t *testing.T) {
+		calls := []string{}
+
+		record := func(name string) dbresolver.Interceptor {
+			return func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				calls = append(calls, name+":"+string(info.Op))
+				return next(ctx, info)
+			}
+		}
+
+		database := setupEventsDB(t, dbresolver.WithInterceptors(record("outer"), record("inner")))
+
+		_, err := database.WithMode(dbresolver.DbWriteMode).Exec(`INSERT INTO events (name) VALUES ('a')`)
+		require.NoError(t, err)
+
+		_, err = database.QueryRow(`SELECT name FROM events`).Values()
+		require.NoError(t, err)
+
+		require.Equal(t, []string{"outer:exec", "inner:exec", "outer:query_row", "inner:query_row"}, calls)
+	})
+
+	t.Run("rewrite statement", func(t *testing.T) {
+		database := setupEventsDB(t, dbresolver.WithInterceptors(
+			func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				info.Statement = strings.Replace(info.Statement, "events_v1", "events", 1)
+				return next(ctx, info)
+			},
+		))
+
+		_, err := database.WithMode(dbresolver.DbWriteMode).Exec(`INSERT INTO events_v1 (name) VALUES ('a')`)
+		require.NoError(t, err)
+
+		rows, err := database.Query(`SELECT name FROM events_v1`)
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+	})
+
+	t.Run("veto", func(t *testing.T) {
+		errVeto := errors.New("no deletes")
+
+		database := setupEventsDB(t, dbresolver.WithInterceptors(
+			func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				if strings.HasPrefix(info.Statement, "DELETE") {
+					return dbresolver.QueryResult{}, errVeto
+				}
+
+				return next(ctx, info)
+			},
+		))
+
+		_, err := database.WithMode(dbresolver.DbWriteMode).Exec(`DELETE FROM events`)
+		require.ErrorIs(t, err, errVeto)
+	})
+
+	t.Run("short circuit", func(t *testing.T) {
+		cached := dbresolver.Rows{&dbresolver.Row{"cached"}}
+
+		database := setupEventsDB(t, dbresolver.WithInterceptors(
+			dbresolver.ChainInterceptors(
+				func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+					return dbresolver.QueryResult{Rows: cached}, nil
+				},
+			),
+		))
+
+		rows, err := database.Query(`SELECT name FROM events`)
+		require.NoError(t, err)
+		require.Equal(t, cached, rows)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		var deadline time.Time
+
+		database := setupEventsDB(t, dbresolver.WithInterceptors(
+			dbresolver.TimeoutInterceptor(time.Minute),
+			func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				deadline, _ = ctx.Deadline()
+				return next(ctx, info)
+			},
+		))
+
+		_, err := database.Query(`SELECT name FROM events`)
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+	})
+
+	t.Run("retry reads only", func(t *testing.T) {
+		errFlaky := errors.New("flaky")
+		attempts := 0
+
+		var retries []dbresolver.RetryAttempt
+
+		database := setupEventsDB(t, dbresolver.WithInterceptors(
+			func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				res, err := next(ctx, info)
+				retries = info.Retries
+				return res, err
+			},
+			dbresolver.RetryInterceptor(3, func(err error) bool { return errors.Is(err, errFlaky) }),
+			func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				attempts++
+				if attempts < 3 {
+					return dbresolver.QueryResult{}, errFlaky
+				}
+
+				return next(ctx, info)
+			},
+		))
+
+		_, err := database.Query(`SELECT name FROM events`)
+		require.NoError(t, err)
+		require.Equal(t, 3, attempts)
+
+		require.Len(t, retries, 2)
+		require.Equal(t, 2, retries[1].Attempt)
+		require.ErrorIs(t, retries[1].Err, errFlaky)
+
+		attempts = 0
+		_, err = database.WithMode(dbresolver.DbWriteMode).Exec(`INSERT INTO events (name) VALUES ('a')`)
+		require.ErrorIs(t, err, errFlaky)
+		require.Equal(t, 1, attempts)
+		require.Empty(t, retries)
+	})
+}
+
+func TestTransactions(t *testing.T) {
+	ops := []dbresolver.Operation{}
+
+	database := setupEventsDB(t, dbresolver.WithInterceptors(
+		func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+			ops = append(ops, info.Op)
+			return next(ctx, info)
+		},
+	))
+
+	t.Run("commit", func(t *testing.T) {
+		tx, err := database.Begin()
+		require.NoError(t, err)
+		require.Equal(t, "events_write", tx.Node().Name)
+
+		_, err = tx.Exec(`INSERT INTO events (name) VALUES ('committed')`)
+		require.NoError(t, err)
+
+		var name string
+		require.NoError(t, tx.QueryRow(`SELECT name FROM events`).Scan(&name))
+		require.Equal(t, "committed", name)
+
+		require.NoError(t, tx.Commit())
+
+		rows, err := database.Query(`SELECT name FROM events`)
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		tx, err := database.Begin()
+		require.NoError(t, err)
+
+		_, err = tx.Exec(`INSERT INTO events (name) VALUES ('rolled_back')`)
+		require.NoError(t, err)
+		require.NoError(t, tx.Rollback())
+
+		rows, err := database.Query(`SELECT name FROM events`)
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+	})
+
+	require.Equal(t, []dbresolver.Operation{
+		dbresolver.OpBegin, dbresolver.OpExec, dbresolver.OpQueryRow, dbresolver.OpCommit, dbresolver.OpQuery,
+		dbresolver.OpBegin, dbresolver.OpExec, dbresolver.OpRollback, dbresolver.OpQuery,
+	}, ops)
+}
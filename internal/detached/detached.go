@@ -0,0 +1,34 @@
+// Package detached provides contexts that outlive their parent, for work
+// that continues after the caller returned, like async hooks or a shared
+// query refreshed in the background.
+package detached
+
+import (
+	"context"
+	"time"
+)
+
+// Context keeps the values of parent, but not its deadline or cancellation.
+func Context(parent context.Context) context.Context {
+	return detachedContext{parent: parent}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (dc detachedContext) Value(key interface{}) interface{} {
+	return dc.parent.Value(key)
+}
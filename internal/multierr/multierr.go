@@ -0,0 +1,29 @@
+// Package multierr matches errors holding a list of errors, like
+// hooks.Errors and dbresolver.ConfigErrors. errors.Is and errors.As only
+// follow Unwrap() []error from Go 1.20 on, so these lists implement Is
+// and As with the functions here, to be matched on older versions too.
+package multierr
+
+import "errors"
+
+// Is reports whether one of errs matches target.
+func Is(errs []error, target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first of errs matching target, and sets target to it.
+func As(errs []error, target interface{}) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
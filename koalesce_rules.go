@@ -0,0 +1,171 @@
+package dbresolver
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type koalesceCtxKey int
+
+const (
+	noCoalesceKey koalesceCtxKey = iota
+	cacheForKey
+)
+
+// NoCoalesce marks every query run with the returned context
+// to always execute, bypassing both coalescing and caching.
+func NoCoalesce(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCoalesceKey, true)
+}
+
+// CacheFor caches the results of queries run with the returned context
+// for ttl, even when the koalescer has no stale-while-revalidate policy.
+// Statements rejected by the koalescer rules are still never cached, and
+// writes do not invalidate the results cached.
+func CacheFor(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, cacheForKey, ttl)
+}
+
+func isNoCoalesce(ctx context.Context) bool {
+	noCoalesce, _ := ctx.Value(noCoalesceKey).(bool)
+	return noCoalesce
+}
+
+func cacheForFromContext(ctx context.Context) (time.Duration, bool) {
+	ttl, ok := ctx.Value(cacheForKey).(time.Duration)
+	return ttl, ok && ttl > 0
+}
+
+// DefaultVolatileFunctions are SQL functions whose result changes between
+// calls. Statements calling any of them are never coalesced or cached.
+var DefaultVolatileFunctions = []string{
+	"nextval", "currval", "setval", "lastval",
+	"random", "rand", "uuid", "gen_random_uuid", "uuid_generate_v1", "uuid_generate_v4",
+	"now", "sysdate", "clock_timestamp", "statement_timestamp", "transaction_timestamp", "timeofday",
+	"txid_current", "last_insert_id", "last_insert_rowid", "changes",
+	"sleep", "pg_sleep",
+}
+
+// volatileKeywords are read without parentheses,
+// e.g SELECT CURRENT_TIMESTAMP.
+var volatileKeywords = []string{
+	"current_timestamp", "current_date", "current_time", "localtime", "localtimestamp",
+}
+
+// KoalesceRules decide per statement whether it may be coalesced or cached.
+//
+// A statement is rejected when it matches any Deny pattern, reads from any
+// DenyTables entry, or calls a volatile function. When Allow or AllowTables
+// are set, a statement must also match one of them.
+type KoalesceRules struct {
+	Allow       []*regexp.Regexp
+	Deny        []*regexp.Regexp
+	AllowTables []string
+	DenyTables  []string
+
+	// VolatileFunctions are checked in addition to DefaultVolatileFunctions
+	VolatileFunctions        []string
+	DisableVolatileDetection bool
+
+	volatile *regexp.Regexp
+}
+
+// WithKoalesceRules sets the rules used by Allows.
+func WithKoalesceRules(rules KoalesceRules) KoalescerOpts {
+	return func(ko *QueryKoalescer) {
+		rules.compile()
+		ko.rules = &rules
+	}
+}
+
+var tableRefPattern = regexp.MustCompile(`(?i)\b(?:from|join)\s+([\w."` + "`" + `\[\]]+)`)
+
+func (r *KoalesceRules) compile() {
+	if r.DisableVolatileDetection {
+		return
+	}
+
+	funcs := append(append([]string{}, DefaultVolatileFunctions...), r.VolatileFunctions...)
+	for i, fn := range funcs {
+		funcs[i] = regexp.QuoteMeta(strings.ToLower(fn))
+	}
+
+	// Only function calls count, so that a column
+	// named uuid or now does not match.
+	// sqlite spells the current time as a 'now' modifier.
+	r.volatile = regexp.MustCompile(
+		`(?i)\b(?:` + strings.Join(funcs, "|") + `)\s*\(` +
+			`|\b(?:` + strings.Join(volatileKeywords, "|") + `)\b` +
+			`|'now'`,
+	)
+}
+
+// Allows reports whether stmt may be coalesced or cached.
+func (r *KoalesceRules) Allows(stmt string) bool {
+	for _, deny := range r.Deny {
+		if deny.MatchString(stmt) {
+			return false
+		}
+	}
+
+	tables := referencedTables(stmt)
+	if hasTable(tables, r.DenyTables) {
+		return false
+	}
+
+	if r.volatile != nil && r.volatile.MatchString(stmt) {
+		return false
+	}
+
+	if len(r.Allow) == 0 && len(r.AllowTables) == 0 {
+		return true
+	}
+
+	for _, allow := range r.Allow {
+		if allow.MatchString(stmt) {
+			return true
+		}
+	}
+
+	return hasTable(tables, r.AllowTables)
+}
+
+// Allows reports whether stmt, run with ctx, may be coalesced or cached.
+func (ko *QueryKoalescer) Allows(ctx context.Context, stmt string) bool {
+	if isNoCoalesce(ctx) {
+		return false
+	}
+
+	return ko.rules.Allows(stmt)
+}
+
+func referencedTables(stmt string) []string {
+	matches := tableRefPattern.FindAllStringSubmatch(stmt, -1)
+	tables := make([]string, 0, len(matches))
+
+	for _, match := range matches {
+		table := strings.ToLower(strings.Trim(match[1], "\"`[]"))
+		tables = append(tables, table)
+	}
+
+	return tables
+}
+
+// hasTable matches schema qualified names against
+// both the qualified and the bare table name.
+func hasTable(tables []string, list []string) bool {
+	for _, table := range tables {
+		bare := table[strings.LastIndex(table, ".")+1:]
+
+		for _, name := range list {
+			name = strings.ToLower(name)
+			if name == table || name == bare {
+				return true
+			}
+		}
+	}
+
+	return false
+}
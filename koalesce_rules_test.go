@@ -0,0 +1,120 @@
+package dbresolver_test
+
+import (
+	"context"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKoalesceRules(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("volatile functions are detected by default", func(t *testing.T) {
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{})
+
+		require.True(t, koala.Allows(ctx, "SELECT * FROM users"))
+		require.True(t, koala.Allows(ctx, "SELECT uuid, now FROM users"))
+
+		require.False(t, koala.Allows(ctx, "SELECT nextval('users_id_seq')"))
+		require.False(t, koala.Allows(ctx, "SELECT RANDOM() FROM users"))
+		require.False(t, koala.Allows(ctx, "SELECT * FROM users WHERE created_at > now ()"))
+		require.False(t, koala.Allows(ctx, "SELECT CURRENT_TIMESTAMP"))
+		require.False(t, koala.Allows(ctx, "SELECT datetime('now')"))
+	})
+
+	t.Run("volatile detection can be extended and disabled", func(t *testing.T) {
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithKoalesceRules(dbresolver.KoalesceRules{
+			VolatileFunctions: []string{"next_ticket"},
+		}))
+		require.False(t, koala.Allows(ctx, "SELECT next_ticket()"))
+
+		koala = dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithKoalesceRules(dbresolver.KoalesceRules{
+			DisableVolatileDetection: true,
+		}))
+		require.True(t, koala.Allows(ctx, "SELECT random()"))
+	})
+
+	t.Run("deny lists", func(t *testing.T) {
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithKoalesceRules(dbresolver.KoalesceRules{
+			Deny:       []*regexp.Regexp{regexp.MustCompile(`(?i)\bcount\(`)},
+			DenyTables: []string{"sessions"},
+		}))
+
+		require.True(t, koala.Allows(ctx, "SELECT * FROM users"))
+		require.False(t, koala.Allows(ctx, "SELECT COUNT(1) FROM users"))
+		require.False(t, koala.Allows(ctx, "SELECT * FROM public.sessions"))
+		require.False(t, koala.Allows(ctx, `SELECT * FROM users u JOIN "sessions" s ON s.user_id = u.id`))
+	})
+
+	t.Run("allow lists", func(t *testing.T) {
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithKoalesceRules(dbresolver.KoalesceRules{
+			Allow:       []*regexp.Regexp{regexp.MustCompile(`^SELECT 1$`)},
+			AllowTables: []string{"countries"},
+		}))
+
+		require.True(t, koala.Allows(ctx, "SELECT 1"))
+		require.True(t, koala.Allows(ctx, "SELECT * FROM countries"))
+		require.False(t, koala.Allows(ctx, "SELECT * FROM users"))
+	})
+
+	t.Run("no coalesce context", func(t *testing.T) {
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{})
+
+		require.False(t, koala.Allows(dbresolver.NoCoalesce(ctx), "SELECT * FROM users"))
+	})
+}
+
+func TestKoalesceContextOptions(t *testing.T) {
+	var calls int32
+
+	fetch := func(context.Context) (interface{}, error) {
+		return int(atomic.AddInt32(&calls, 1)), nil
+	}
+
+	t.Run("cache for keeps results without a stale-while-revalidate policy", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{})
+		ctx := dbresolver.CacheFor(context.Background(), time.Hour)
+
+		<-koala.DoWithContext(ctx, "key", fetch)
+		res := <-koala.DoWithContext(ctx, "key", fetch)
+
+		require.Equal(t, 1, res.Val)
+		require.True(t, res.Shared)
+
+		// Callers without the option do not read the cache
+		res = <-koala.DoWithContext(context.Background(), "key", fetch)
+		require.Equal(t, 2, res.Val)
+	})
+
+	t.Run("cache for expires", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{})
+		ctx := dbresolver.CacheFor(context.Background(), 10*time.Millisecond)
+
+		<-koala.DoWithContext(ctx, "key", fetch)
+		time.Sleep(20 * time.Millisecond)
+
+		res := <-koala.DoWithContext(ctx, "key", fetch)
+		require.Equal(t, 2, res.Val)
+	})
+
+	t.Run("no coalesce always executes", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		koala := dbresolver.NewKoalescer(
+			&dbresolver.NoopEvictor{},
+			dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour),
+		)
+
+		<-koala.DoWithContext(context.Background(), "key", fetch)
+		res := <-koala.DoWithContext(dbresolver.NoCoalesce(context.Background()), "key", fetch)
+
+		require.Equal(t, 2, res.Val)
+		require.False(t, res.Shared)
+	})
+}
@@ -3,8 +3,11 @@ package dbresolver
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/go-batteries/dbresolver/internal/detached"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -39,31 +42,133 @@ func (*NoopEvictor) HasEvicted() bool {
 	return false
 }
 
+// Errors returned to a waiter that stopped waiting for a coalesced
+// query. The shared query itself keeps running for the other waiters.
+// They match context.DeadlineExceeded and context.Canceled with errors.Is.
 var (
-	ErrKoalesceTimeout   = errors.New("timeout")
-	ErrKoalesceCancelled = errors.New("cancelled")
+	ErrKoalesceTimeout   error = &koalesceWaitError{msg: "koalesce: wait deadline exceeded", cause: context.DeadlineExceeded}
+	ErrKoalesceCancelled error = &koalesceWaitError{msg: "koalesce: wait cancelled", cause: context.Canceled}
 )
 
+type koalesceWaitError struct {
+	msg   string
+	cause error
+}
+
+func (e *koalesceWaitError) Error() string {
+	return e.msg
+}
+
+func (e *koalesceWaitError) Is(target error) bool {
+	return target == e.cause
+}
+
+// StaleWhileRevalidate keeps the result of a coalesced query around
+// after the call returns. Until SoftTTL the cached value is served as is.
+// Between SoftTTL and HardTTL the stale value is served immediately while
+// a single background refresh runs. After HardTTL callers block on a
+// fresh query.
+type StaleWhileRevalidate struct {
+	SoftTTL time.Duration
+	HardTTL time.Duration
+}
+
+type cachedResult struct {
+	val      interface{}
+	storedAt time.Time
+	ttl      StaleWhileRevalidate
+}
+
+type KoalescerOpts func(ko *QueryKoalescer)
+
+// WithStaleWhileRevalidate enables result caching with the given soft
+// and hard TTLs. A hard TTL smaller than the soft TTL is raised to it.
+// Writes do not invalidate the cached reads of the tables they change,
+// a cached result is served until its hard TTL whatever was written.
+func WithStaleWhileRevalidate(softTTL, hardTTL time.Duration) KoalescerOpts {
+	return func(ko *QueryKoalescer) {
+		if hardTTL < softTTL {
+			hardTTL = softTTL
+		}
+
+		ko.swr = &StaleWhileRevalidate{SoftTTL: softTTL, HardTTL: hardTTL}
+	}
+}
+
+// WithKoalescerHooks sets the emitter used for koalescer events.
+// When not set, Register hands over the database hooks.
+func WithKoalescerHooks(eventHandler hooks.EventEmitter) KoalescerOpts {
+	return func(ko *QueryKoalescer) {
+		ko.hooks = eventHandler
+	}
+}
+
+// koalescedCall is a query shared by one or more waiters.
+// The call is cancelled once every waiter has left.
+type koalescedCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	started time.Time
+	waiters int
+	dups    int
+	// forgotten is set when the key is forgotten while the call
+	// runs, so that it does not write its result back into the cache
+	forgotten bool
+
+	val interface{}
+	err error
+}
+
 type QueryKoalescer struct {
-	g        *singleflight.Group
 	evictior KoalesceEvictor
+	hooks    hooks.EventEmitter
+	swr      *StaleWhileRevalidate
+	rules    *KoalesceRules
+
+	mu         *sync.Mutex
+	calls      map[string]*koalescedCall
+	cache      map[string]cachedResult
+	refreshing map[string]bool
+	stats      *koalescerCounters
 }
 
-func NewKoalescer(evictor KoalesceEvictor) *QueryKoalescer {
-	return &QueryKoalescer{
-		g:        new(singleflight.Group),
-		evictior: evictor,
+func NewKoalescer(evictor KoalesceEvictor, opts ...KoalescerOpts) *QueryKoalescer {
+	ko := &QueryKoalescer{
+		evictior:   evictor,
+		mu:         &sync.Mutex{},
+		calls:      make(map[string]*koalescedCall),
+		cache:      make(map[string]cachedResult),
+		refreshing: make(map[string]bool),
+		stats:      newKoalescerCounters(),
+	}
+
+	// Volatile function detection is on by default
+	WithKoalesceRules(KoalesceRules{})(ko)
+
+	for _, opt := range opts {
+		opt(ko)
 	}
+
+	return ko
 }
 
 func (ko *QueryKoalescer) Forget(query string) error {
-	ko.g.Forget(query)
+	ko.mu.Lock()
+	defer ko.mu.Unlock()
+
+	if call, ok := ko.calls[query]; ok {
+		call.forgotten = true
+	}
+
+	delete(ko.calls, query)
+	delete(ko.cache, query)
+
 	return nil
 }
 
 func (ko *QueryKoalescer) Evict(query string) bool {
 	if ko.evictior.HasEvicted() {
-		ko.g.Forget(query)
+		ko.Forget(query)
 		return true
 	}
 
@@ -71,35 +176,232 @@ func (ko *QueryKoalescer) Evict(query string) bool {
 }
 
 func (ko *QueryKoalescer) ForgetWithContext(ctx context.Context, query string) error {
-	select {
-	case <-ctx.Done():
-		return ErrKoalesceCancelled
-	case <-time.After(10 * time.Second):
-		return ErrKoalesceTimeout
-	default:
-		ko.g.Forget(query)
+	if err := ctx.Err(); err != nil {
+		return koalesceError(err)
 	}
 
-	return nil
+	return ko.Forget(query)
 }
 
 func (ko *QueryKoalescer) DoChan(query string, fn func() (interface{}, error)) <-chan singleflight.Result {
+	return ko.DoWithContext(context.Background(), query, func(context.Context) (interface{}, error) {
+		return fn()
+	})
+}
+
+// DoWithContext runs fn once for all concurrent callers of the same query.
+// Each caller stops waiting when its own ctx is done, and receives
+// ErrKoalesceTimeout or ErrKoalesceCancelled. The context passed to fn
+// carries the values of the first caller's ctx, and is cancelled only
+// when every caller has stopped waiting.
+func (ko *QueryKoalescer) DoWithContext(ctx context.Context, query string, fn func(context.Context) (interface{}, error)) <-chan singleflight.Result {
+	ch := make(chan singleflight.Result, 1)
+
+	if err := ctx.Err(); err != nil {
+		ch <- singleflight.Result{Err: koalesceError(err)}
+		return ch
+	}
+
 	// If its time to evict the data evict it
 	ko.Evict(query)
 
-	return ko.g.DoChan(query, fn)
+	if isNoCoalesce(ctx) {
+		go func() {
+			val, err := fn(ctx)
+			ch <- singleflight.Result{Val: val, Err: err}
+		}()
+
+		return ch
+	}
+
+	ko.stats.touch(query)
+
+	ttl, cacheable := ko.cacheTTL(ctx)
+	if cacheable {
+		val, fresh, ok := ko.lookup(query)
+		ko.stats.cacheLookup(ok)
+
+		if ok {
+			if !fresh {
+				ko.revalidate(ctx, query, ttl, fn)
+			}
+
+			ko.stats.shared()
+			ch <- singleflight.Result{Val: val, Shared: true}
+			return ch
+		}
+	}
+
+	var cacheFor *StaleWhileRevalidate
+	if cacheable {
+		cacheFor = &ttl
+	}
+
+	call := ko.join(ctx, query, cacheFor, false, fn)
+
+	go func() {
+		select {
+		case <-call.done:
+			if call.dups > 0 {
+				ko.stats.shared()
+			}
+
+			ch <- singleflight.Result{Val: call.val, Err: call.err, Shared: call.dups > 0}
+		case <-ctx.Done():
+			ko.leave(query, call)
+			ch <- singleflight.Result{Err: koalesceError(ctx.Err())}
+		}
+	}()
+
+	return ch
 }
 
-func (ko *QueryKoalescer) DoWithContext(ctx context.Context, query string, fn func() (interface{}, error)) <-chan singleflight.Result {
-	select {
-	case <-ctx.Done():
-		res := singleflight.Result{
-			Err: ErrKoalesceCancelled,
+// join adds a waiter to the call in flight for query, or starts a
+// new call if there is none. A new call caches its result for ttl,
+// when ttl is not nil. A refresh is not a duplicate of the call it
+// joins, since no caller waits on it.
+func (ko *QueryKoalescer) join(ctx context.Context, query string, ttl *StaleWhileRevalidate, refresh bool, fn func(context.Context) (interface{}, error)) *koalescedCall {
+	ko.mu.Lock()
+	defer ko.mu.Unlock()
+
+	if call, ok := ko.calls[query]; ok {
+		call.waiters++
+
+		if !refresh {
+			call.dups++
+			ko.stats.suppressed()
 		}
-		ch := make(chan singleflight.Result)
-		ch <- res
-		return ch
-	default:
-		return ko.DoChan(query, fn)
+
+		return call
+	}
+
+	callCtx, cancel := context.WithCancel(detached.Context(ctx))
+	call := &koalescedCall{
+		done:    make(chan struct{}),
+		cancel:  cancel,
+		started: time.Now(),
+		waiters: 1,
 	}
+
+	ko.calls[query] = call
+	go ko.run(callCtx, query, call, ttl, fn)
+
+	return call
+}
+
+func (ko *QueryKoalescer) run(ctx context.Context, query string, call *koalescedCall, ttl *StaleWhileRevalidate, fn func(context.Context) (interface{}, error)) {
+	val, err := fn(ctx)
+
+	ko.mu.Lock()
+	if ko.calls[query] == call {
+		delete(ko.calls, query)
+	}
+
+	// Only cache the result, if the key was not
+	// forgotten while the query was running.
+	if err == nil && ttl != nil && !call.forgotten {
+		ko.cache[query] = cachedResult{val: val, storedAt: time.Now(), ttl: *ttl}
+	}
+
+	call.val, call.err = val, err
+	ko.mu.Unlock()
+
+	call.cancel()
+	close(call.done)
+}
+
+// leave removes a waiter from call, and cancels
+// the shared query when it was the last one.
+func (ko *QueryKoalescer) leave(query string, call *koalescedCall) {
+	ko.mu.Lock()
+	defer ko.mu.Unlock()
+
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+
+	if ko.calls[query] == call {
+		delete(ko.calls, query)
+	}
+
+	call.cancel()
+}
+
+// lookup returns the cached value for query, and whether it is
+// still within the soft TTL. Entries past the hard TTL are dropped.
+func (ko *QueryKoalescer) lookup(query string) (val interface{}, fresh bool, ok bool) {
+	ko.mu.Lock()
+	defer ko.mu.Unlock()
+
+	entry, ok := ko.cache[query]
+	if !ok {
+		return nil, false, false
+	}
+
+	age := time.Since(entry.storedAt)
+	if age >= entry.ttl.HardTTL {
+		delete(ko.cache, query)
+		return nil, false, false
+	}
+
+	return entry.val, age < entry.ttl.SoftTTL, true
+}
+
+// cacheTTL returns how long results for ctx are kept. A CacheFor
+// duration on ctx replaces the soft TTL of the stale-while-revalidate
+// policy, and keeps the same stale window.
+func (ko *QueryKoalescer) cacheTTL(ctx context.Context) (StaleWhileRevalidate, bool) {
+	ttl, ok := cacheForFromContext(ctx)
+	if !ok {
+		if ko.swr == nil {
+			return StaleWhileRevalidate{}, false
+		}
+
+		return *ko.swr, true
+	}
+
+	policy := StaleWhileRevalidate{SoftTTL: ttl, HardTTL: ttl}
+	if ko.swr != nil {
+		policy.HardTTL += ko.swr.HardTTL - ko.swr.SoftTTL
+	}
+
+	return policy, true
+}
+
+// revalidate starts a background refresh for query, unless
+// one is already running. The refresh joins any call in flight
+// and never leaves it, so it is not cancelled by other waiters.
+func (ko *QueryKoalescer) revalidate(ctx context.Context, query string, ttl StaleWhileRevalidate, fn func(context.Context) (interface{}, error)) {
+	ko.mu.Lock()
+	if ko.refreshing[query] {
+		ko.mu.Unlock()
+		return
+	}
+
+	ko.refreshing[query] = true
+	ko.mu.Unlock()
+
+	call := ko.join(ctx, query, &ttl, true, fn)
+	ctx = detached.Context(ctx)
+
+	go func() {
+		<-call.done
+
+		ko.mu.Lock()
+		delete(ko.refreshing, query)
+		ko.mu.Unlock()
+
+		if call.err != nil && ko.hooks != nil {
+			ko.hooks.EmitContext(ctx, EventKoalesceRefreshFailed, KoalesceRefreshFailedEvent{Key: query, Err: call.err})
+		}
+	}()
+}
+
+func koalesceError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrKoalesceTimeout
+	}
+
+	return ErrKoalesceCancelled
 }
@@ -0,0 +1,221 @@
+package dbresolver
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxTrackedKeys bounds the number of keys counted for HotKeys.
+// When the limit is hit, the colder half is dropped.
+const maxTrackedKeys = 1024
+
+const defaultHotKeys = 10
+
+type koalescerCounters struct {
+	sharedResults        uint64
+	duplicatesSuppressed uint64
+	cacheHits            uint64
+	cacheMisses          uint64
+
+	mu   *sync.Mutex
+	hits map[string]uint64
+}
+
+func newKoalescerCounters() *koalescerCounters {
+	return &koalescerCounters{
+		mu:   &sync.Mutex{},
+		hits: make(map[string]uint64),
+	}
+}
+
+func (c *koalescerCounters) shared() {
+	atomic.AddUint64(&c.sharedResults, 1)
+}
+
+func (c *koalescerCounters) suppressed() {
+	atomic.AddUint64(&c.duplicatesSuppressed, 1)
+}
+
+func (c *koalescerCounters) cacheLookup(hit bool) {
+	if hit {
+		atomic.AddUint64(&c.cacheHits, 1)
+		return
+	}
+
+	atomic.AddUint64(&c.cacheMisses, 1)
+}
+
+func (c *koalescerCounters) touch(query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.hits[query]; !ok && len(c.hits) >= maxTrackedKeys {
+		c.prune()
+	}
+
+	c.hits[query]++
+}
+
+func (c *koalescerCounters) prune() {
+	keys := sortedKeyHits(c.hits)
+
+	for _, kh := range keys[len(keys)/2:] {
+		delete(c.hits, kh.Key)
+	}
+}
+
+func (c *koalescerCounters) hotKeys(n int) []KeyHits {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n <= 0 {
+		return []KeyHits{}
+	}
+
+	keys := sortedKeyHits(c.hits)
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+
+	return keys
+}
+
+func sortedKeyHits(hits map[string]uint64) []KeyHits {
+	keys := make([]KeyHits, 0, len(hits))
+	for key, count := range hits {
+		keys = append(keys, KeyHits{Key: key, Hits: count})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Hits == keys[j].Hits {
+			return keys[i].Key < keys[j].Key
+		}
+
+		return keys[i].Hits > keys[j].Hits
+	})
+
+	return keys
+}
+
+// KeyHits is the number of times a query key was requested.
+type KeyHits struct {
+	Key  string `json:"key"`
+	Hits uint64 `json:"hits"`
+}
+
+// InFlightQuery is a coalesced query that is still running.
+type InFlightQuery struct {
+	Key     string        `json:"key"`
+	Waiters int           `json:"waiters"`
+	Started time.Time     `json:"started"`
+	Age     time.Duration `json:"age"`
+}
+
+type KoalescerStats struct {
+	InFlightKeys int            `json:"in_flight_keys"`
+	Waiters      map[string]int `json:"waiters"`
+
+	// SharedResults counts results handed to a caller
+	// that did not run the query itself.
+	SharedResults uint64 `json:"shared_results"`
+	// DuplicatesSuppressed counts callers that joined
+	// a query already in flight.
+	DuplicatesSuppressed uint64 `json:"duplicates_suppressed"`
+
+	CacheHits     uint64  `json:"cache_hits"`
+	CacheMisses   uint64  `json:"cache_misses"`
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+
+	HotKeys []KeyHits `json:"hot_keys"`
+}
+
+// Stats returns a snapshot of the koalescer counters,
+// with the topN most requested keys, none when topN <= 0.
+func (ko *QueryKoalescer) Stats(topN int) KoalescerStats {
+	stats := KoalescerStats{
+		Waiters:              make(map[string]int),
+		SharedResults:        atomic.LoadUint64(&ko.stats.sharedResults),
+		DuplicatesSuppressed: atomic.LoadUint64(&ko.stats.duplicatesSuppressed),
+		CacheHits:            atomic.LoadUint64(&ko.stats.cacheHits),
+		CacheMisses:          atomic.LoadUint64(&ko.stats.cacheMisses),
+		HotKeys:              ko.stats.hotKeys(topN),
+	}
+
+	if lookups := stats.CacheHits + stats.CacheMisses; lookups > 0 {
+		stats.CacheHitRatio = float64(stats.CacheHits) / float64(lookups)
+	}
+
+	ko.mu.Lock()
+	defer ko.mu.Unlock()
+
+	stats.InFlightKeys = len(ko.calls)
+	for key, call := range ko.calls {
+		stats.Waiters[key] = call.waiters
+	}
+
+	return stats
+}
+
+// InFlight lists the queries currently running, oldest first.
+func (ko *QueryKoalescer) InFlight() []InFlightQuery {
+	ko.mu.Lock()
+	queries := make([]InFlightQuery, 0, len(ko.calls))
+
+	for key, call := range ko.calls {
+		queries = append(queries, InFlightQuery{
+			Key:     key,
+			Waiters: call.waiters,
+			Started: call.started,
+			Age:     time.Since(call.started),
+		})
+	}
+	ko.mu.Unlock()
+
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Started.Before(queries[j].Started)
+	})
+
+	return queries
+}
+
+type koalescerDebugDump struct {
+	Stats    KoalescerStats  `json:"stats"`
+	InFlight []InFlightQuery `json:"in_flight"`
+}
+
+func (ko *QueryKoalescer) debugDump(topN int) koalescerDebugDump {
+	return koalescerDebugDump{
+		Stats:    ko.Stats(topN),
+		InFlight: ko.InFlight(),
+	}
+}
+
+// Handler serves the stats and the in flight queries as JSON.
+// The number of hot keys defaults to 10, and can be changed
+// with the top query parameter.
+func (ko *QueryKoalescer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		topN := defaultHotKeys
+		if top, err := strconv.Atoi(r.URL.Query().Get("top")); err == nil && top > 0 {
+			topN = top
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ko.debugDump(topN))
+	})
+}
+
+// PublishExpvar exposes the same dump as Handler under name
+// in expvar, which is served on /debug/vars.
+// Like expvar.Publish, it panics if name is already in use.
+func (ko *QueryKoalescer) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return ko.debugDump(defaultHotKeys)
+	}))
+}
@@ -0,0 +1,137 @@
+package dbresolver_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKoalescerStats(t *testing.T) {
+	koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{})
+
+	block := make(chan struct{})
+	query := func(context.Context) (interface{}, error) {
+		<-block
+		return "rows", nil
+	}
+
+	first := koala.DoWithContext(context.Background(), "hot", query)
+	second := koala.DoWithContext(context.Background(), "hot", query)
+	third := koala.DoWithContext(context.Background(), "cold", query)
+
+	stats := koala.Stats(1)
+	require.Equal(t, 2, stats.InFlightKeys)
+	require.Equal(t, map[string]int{"hot": 2, "cold": 1}, stats.Waiters)
+	require.Equal(t, uint64(1), stats.DuplicatesSuppressed)
+	require.Equal(t, []dbresolver.KeyHits{{Key: "hot", Hits: 2}}, stats.HotKeys)
+
+	inFlight := koala.InFlight()
+	require.Len(t, inFlight, 2)
+	require.Equal(t, "hot", inFlight[0].Key)
+	require.Equal(t, 2, inFlight[0].Waiters)
+
+	close(block)
+	<-first
+	<-second
+	<-third
+
+	stats = koala.Stats(10)
+	require.Equal(t, 0, stats.InFlightKeys)
+	require.Equal(t, uint64(2), stats.SharedResults)
+	require.Empty(t, koala.InFlight())
+
+	require.Empty(t, koala.Stats(0).HotKeys)
+	require.Empty(t, koala.Stats(-1).HotKeys)
+}
+
+func TestKoalescerCacheStats(t *testing.T) {
+	koala := dbresolver.NewKoalescer(
+		&dbresolver.NoopEvictor{},
+		dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour),
+	)
+
+	query := func(context.Context) (interface{}, error) {
+		return "rows", nil
+	}
+
+	for i := 0; i < 4; i++ {
+		<-koala.DoWithContext(context.Background(), "key", query)
+	}
+
+	stats := koala.Stats(10)
+	require.Equal(t, uint64(3), stats.CacheHits)
+	require.Equal(t, uint64(1), stats.CacheMisses)
+	require.Equal(t, 0.75, stats.CacheHitRatio)
+}
+
+func TestKoalescerRefreshStats(t *testing.T) {
+	koala := dbresolver.NewKoalescer(
+		&dbresolver.NoopEvictor{},
+		dbresolver.WithStaleWhileRevalidate(10*time.Millisecond, time.Hour),
+	)
+
+	blocked := func(release chan struct{}, val int) func(context.Context) (interface{}, error) {
+		return func(context.Context) (interface{}, error) {
+			<-release
+			return val, nil
+		}
+	}
+
+	// an abandoned call caches its result while another one is in flight
+	first, second := make(chan struct{}), make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := koala.DoWithContext(ctx, "key", blocked(first, 1))
+	cancel()
+	require.ErrorIs(t, (<-abandoned).Err, dbresolver.ErrKoalesceCancelled)
+
+	inFlight := koala.DoWithContext(context.Background(), "key", blocked(second, 2))
+	close(first)
+
+	// the stale result is refreshed by joining the call in flight
+	time.Sleep(20 * time.Millisecond)
+
+	res := <-koala.DoWithContext(context.Background(), "key", blocked(second, 2))
+	require.Equal(t, 1, res.Val)
+
+	close(second)
+
+	res = <-inFlight
+	require.Equal(t, 2, res.Val)
+	require.False(t, res.Shared)
+	require.Zero(t, koala.Stats(0).DuplicatesSuppressed)
+}
+
+func TestKoalescerHandler(t *testing.T) {
+	koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{})
+
+	<-koala.DoChan("key", func() (interface{}, error) {
+		return "rows", nil
+	})
+
+	rec := httptest.NewRecorder()
+	koala.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/koalescer?top=5", nil))
+
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var dump struct {
+		Stats    dbresolver.KoalescerStats  `json:"stats"`
+		InFlight []dbresolver.InFlightQuery `json:"in_flight"`
+	}
+
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&dump))
+	require.Equal(t, []dbresolver.KeyHits{{Key: "key", Hits: 1}}, dump.Stats.HotKeys)
+	require.Empty(t, dump.InFlight)
+
+	// a bad top falls back to the default
+	rec = httptest.NewRecorder()
+	koala.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/koalescer?top=-1", nil))
+
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&dump))
+	require.Len(t, dump.Stats.HotKeys, 1)
+}
@@ -1,11 +1,16 @@
 package dbresolver_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -122,3 +127,249 @@ func Test_SingleFlight(t *testing.T) {
 	// 	t.Errorf("two calls should have returned same value %v %v\n", prev, next)
 	// }
 }
+
+func Test_KoalesceStaleWhileRevalidate(t *testing.T) {
+	var calls int32
+
+	fetch := func() (interface{}, error) {
+		return int(atomic.AddInt32(&calls, 1)), nil
+	}
+
+	t.Run("serves cached value until soft ttl", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		koala := dbresolver.NewKoalescer(
+			&dbresolver.NoopEvictor{},
+			dbresolver.WithStaleWhileRevalidate(time.Hour, 2*time.Hour),
+		)
+
+		first := <-koala.DoChan("key", fetch)
+		second := <-koala.DoChan("key", fetch)
+
+		require.Equal(t, 1, first.Val)
+		require.Equal(t, 1, second.Val)
+		require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("serves stale value and refreshes once in background", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		koala := dbresolver.NewKoalescer(
+			&dbresolver.NoopEvictor{},
+			dbresolver.WithStaleWhileRevalidate(20*time.Millisecond, time.Hour),
+		)
+
+		<-koala.DoChan("key", fetch)
+		time.Sleep(30 * time.Millisecond)
+
+		block := make(chan struct{})
+		slowFetch := func() (interface{}, error) {
+			<-block
+			return fetch()
+		}
+
+		for i := 0; i < 5; i++ {
+			res := <-koala.DoChan("key", slowFetch)
+			require.Equal(t, 1, res.Val)
+		}
+
+		close(block)
+
+		require.Eventually(t, func() bool {
+			res := <-koala.DoChan("key", fetch)
+			return res.Val == 2
+		}, time.Second, 5*time.Millisecond)
+
+		require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("blocks after hard ttl", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		koala := dbresolver.NewKoalescer(
+			&dbresolver.NoopEvictor{},
+			dbresolver.WithStaleWhileRevalidate(10*time.Millisecond, 20*time.Millisecond),
+		)
+
+		<-koala.DoChan("key", fetch)
+		time.Sleep(30 * time.Millisecond)
+
+		res := <-koala.DoChan("key", fetch)
+		require.Equal(t, 2, res.Val)
+	})
+
+	t.Run("forget drops the cached value", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		koala := dbresolver.NewKoalescer(
+			&dbresolver.NoopEvictor{},
+			dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour),
+		)
+
+		<-koala.DoChan("key", fetch)
+		koala.Forget("key")
+
+		res := <-koala.DoChan("key", fetch)
+		require.Equal(t, 2, res.Val)
+	})
+
+	t.Run("forget only stops the caching of its own key", func(t *testing.T) {
+		koala := dbresolver.NewKoalescer(
+			&dbresolver.NoopEvictor{},
+			dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour),
+		)
+
+		release := make(chan struct{})
+		slow := func(val int) func() (interface{}, error) {
+			return func() (interface{}, error) {
+				<-release
+				return val, nil
+			}
+		}
+
+		key := koala.DoChan("key", slow(1))
+		other := koala.DoChan("other", slow(1))
+
+		time.Sleep(10 * time.Millisecond)
+		koala.Forget("key")
+		close(release)
+
+		require.Equal(t, 1, (<-key).Val)
+		require.Equal(t, 1, (<-other).Val)
+
+		res := <-koala.DoChan("key", func() (interface{}, error) { return 2, nil })
+		require.Equal(t, 2, res.Val)
+
+		res = <-koala.DoChan("other", func() (interface{}, error) { return 2, nil })
+		require.Equal(t, 1, res.Val)
+		require.True(t, res.Shared)
+	})
+
+	t.Run("refresh failures emit hook events", func(t *testing.T) {
+		failures := make(chan error, 1)
+
+		store := hooks.NewEventStore()
+		store.On(dbresolver.EventKoalesceRefreshFailed, func(payload interface{}) hooks.Result {
+			failures <- payload.(dbresolver.KoalesceRefreshFailedEvent).Err
+			return hooks.Result{}
+		})
+
+		koala := dbresolver.NewKoalescer(
+			&dbresolver.NoopEvictor{},
+			dbresolver.WithStaleWhileRevalidate(10*time.Millisecond, time.Hour),
+			dbresolver.WithKoalescerHooks(store),
+		)
+
+		<-koala.DoChan("key", fetch)
+		time.Sleep(20 * time.Millisecond)
+
+		refreshErr := errors.New("replica down")
+		res := <-koala.DoChan("key", func() (interface{}, error) {
+			return nil, refreshErr
+		})
+		require.NoError(t, res.Err)
+
+		select {
+		case err := <-failures:
+			require.Equal(t, refreshErr, err)
+		case <-time.After(time.Second):
+			t.Fatal("expected refresh failure event")
+		}
+	})
+}
+
+func Test_KoalesceWithContext(t *testing.T) {
+	t.Run("returns immediately when context is already done", func(t *testing.T) {
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		res := <-koala.DoWithContext(ctx, "key", func(context.Context) (interface{}, error) {
+			t.Error("query should not run for a cancelled context")
+			return nil, nil
+		})
+
+		require.ErrorIs(t, res.Err, dbresolver.ErrKoalesceCancelled)
+		require.ErrorIs(t, res.Err, context.Canceled)
+	})
+
+	t.Run("waiter abandons on its own deadline without cancelling the query", func(t *testing.T) {
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{})
+
+		block := make(chan struct{})
+		queryErr := make(chan error, 1)
+
+		query := func(ctx context.Context) (interface{}, error) {
+			select {
+			case <-block:
+				queryErr <- nil
+				return "rows", nil
+			case <-ctx.Done():
+				queryErr <- ctx.Err()
+				return nil, ctx.Err()
+			}
+		}
+
+		patient := koala.DoWithContext(context.Background(), "key", query)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		impatient := <-koala.DoWithContext(ctx, "key", query)
+		require.ErrorIs(t, impatient.Err, dbresolver.ErrKoalesceTimeout)
+		require.ErrorIs(t, impatient.Err, context.DeadlineExceeded)
+		require.NotErrorIs(t, impatient.Err, dbresolver.ErrKoalesceCancelled)
+
+		close(block)
+
+		res := <-patient
+		require.NoError(t, res.Err)
+		require.Equal(t, "rows", res.Val)
+		require.True(t, res.Shared)
+		require.NoError(t, <-queryErr)
+	})
+
+	t.Run("query is cancelled once every waiter is gone", func(t *testing.T) {
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{})
+
+		queryErr := make(chan error, 1)
+		query := func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			queryErr <- ctx.Err()
+			return nil, ctx.Err()
+		}
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		ctx2, cancel2 := context.WithCancel(context.Background())
+
+		first := koala.DoWithContext(ctx1, "key", query)
+		second := koala.DoWithContext(ctx2, "key", query)
+
+		cancel1()
+		require.ErrorIs(t, (<-first).Err, dbresolver.ErrKoalesceCancelled)
+
+		select {
+		case <-queryErr:
+			t.Fatal("query should keep running while a waiter is left")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		cancel2()
+		require.ErrorIs(t, (<-second).Err, dbresolver.ErrKoalesceCancelled)
+
+		select {
+		case err := <-queryErr:
+			require.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			t.Fatal("query should have been cancelled")
+		}
+	})
+
+	t.Run("forget with a done context", func(t *testing.T) {
+		koala := dbresolver.NewKoalescer(&dbresolver.NoopEvictor{})
+
+		require.NoError(t, koala.ForgetWithContext(context.Background(), "key"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		require.ErrorIs(t, koala.ForgetWithContext(ctx, "key"), dbresolver.ErrKoalesceCancelled)
+	})
+}
@@ -0,0 +1,113 @@
+package dbresolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Logger is satisfied by *slog.Logger. Args are alternating keys and values.
+//
+//	db, err := dbresolver.New(config, dbresolver.WithLogger(slog.Default()))
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// ArgRedactor decides what is logged of the args of a statement.
+// A nil result leaves the args out.
+type ArgRedactor func(args []interface{}) []interface{}
+
+// RedactArgs logs the type of every arg instead of its value,
+// e.g string(12). It is the default.
+func RedactArgs(args []interface{}) []interface{} {
+	types := argTypes(args)
+
+	redacted := make([]interface{}, len(types))
+	for i, t := range types {
+		redacted[i] = t
+	}
+
+	return redacted
+}
+
+// OmitArgs leaves the args out of the logs.
+func OmitArgs([]interface{}) []interface{} {
+	return nil
+}
+
+// ShowArgs logs the args as they are.
+func ShowArgs(args []interface{}) []interface{} {
+	return args
+}
+
+// WithLogger logs node selection at debug level, health and topology
+// changes at info and warn, and failed statements at error.
+func WithLogger(logger Logger) DataBaseOpts {
+	return func(d *Database) {
+		d.logger = logger
+	}
+}
+
+// WithArgRedaction sets how the args of failed statements are logged.
+func WithArgRedaction(redact ArgRedactor) DataBaseOpts {
+	return func(d *Database) {
+		d.redact = redact
+	}
+}
+
+func (d *Database) logQueryError(event AfterQueryEvent) {
+	// the caller giving up is not a database failure
+	if errors.Is(event.Err, context.Canceled) {
+		return
+	}
+
+	args := []interface{}{
+		"op", string(event.Operation),
+		"statement", event.Statement,
+		"node", event.Node,
+		"role", string(event.Role),
+		"duration", event.Duration,
+		"err", event.Err,
+	}
+
+	if redacted := d.redact(event.Args); redacted != nil {
+		args = append(args, "args", redacted)
+	}
+
+	d.logger.Error("dbresolver: query failed", args...)
+}
+
+// CheckHealth pings every node, and logs the nodes going down or back up.
+// Nodes found up on their first check are not logged. It returns the
+// error of the first node found down.
+func (d *Database) CheckHealth(ctx context.Context) error {
+	nodes := d.Nodes()
+
+	var firstErr error
+
+	for _, node := range nodes {
+		previous, err := node.checkHealth(ctx)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", node.Name, err)
+		}
+
+		switch {
+		case previous != healthDown && err != nil:
+			d.logger.Warn("dbresolver: node down", "node", node.Name, "role", string(node.Role()), "err", err)
+		case previous == healthDown && err == nil:
+			d.logger.Info("dbresolver: node up", "node", node.Name, "role", string(node.Role()))
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,201 @@
+package dbresolver_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/stretchr/testify/require"
+)
+
+type logEntry struct {
+	level string
+	msg   string
+	args  map[string]interface{}
+}
+
+type recordingLogger struct {
+	mu      *sync.Mutex
+	entries []logEntry
+}
+
+func newRecordingLogger() *recordingLogger {
+	return &recordingLogger{mu: &sync.Mutex{}}
+}
+
+func (l *recordingLogger) record(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := logEntry{level: level, msg: msg, args: map[string]interface{}{}}
+	for i := 0; i+1 < len(args); i += 2 {
+		entry.args[fmt.Sprint(args[i])] = args[i+1]
+	}
+
+	l.entries = append(l.entries, entry)
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.record("debug", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record("info", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record("warn", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.record("error", msg, args) }
+
+func (l *recordingLogger) find(level, msg string) []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	found := []logEntry{}
+	for _, entry := range l.entries {
+		if entry.level == level && entry.msg == msg {
+			found = append(found, entry)
+		}
+	}
+
+	return found
+}
+
+func TestLogger(t *testing.T) {
+	t.Run("routing decisions and registration", func(t *testing.T) {
+		logger := newRecordingLogger()
+		database := setupEventsDB(t, dbresolver.WithLogger(logger))
+
+		require.Len(t, logger.find("info", "dbresolver: registered"), 1)
+
+		_, err := database.Query(`SELECT name FROM events`)
+		require.NoError(t, err)
+
+		selected := logger.find("debug", "dbresolver: selected node")
+		require.Len(t, selected, 1)
+		require.Equal(t, "events_read", selected[0].args["node"])
+		require.Equal(t, "replica", selected[0].args["role"])
+	})
+
+	t.Run("query errors with redacted args", func(t *testing.T) {
+		logger := newRecordingLogger()
+		database := setupEventsDB(t, dbresolver.WithLogger(logger))
+
+		_, err := database.Query(`SELECT missing FROM events WHERE name = ?`, "secret")
+		require.Error(t, err)
+
+		failed := logger.find("error", "dbresolver: query failed")
+		require.Len(t, failed, 1)
+		require.Equal(t, "events_read", failed[0].args["node"])
+		require.Equal(t, []interface{}{"string(6)"}, failed[0].args["args"])
+		require.Equal(t, err, failed[0].args["err"])
+	})
+
+	t.Run("no rows is not a failure", func(t *testing.T) {
+		logger := newRecordingLogger()
+		store := hooks.NewEventStore()
+		failed := collect(store, dbresolver.EventQueryError)
+		after := collect(store, dbresolver.EventAfterQueryRun)
+
+		database := setupEventsDB(t, dbresolver.WithLogger(logger), dbresolver.WithHooks(store))
+
+		stmt, err := database.Prepare(`SELECT name FROM events WHERE id = ?`)
+		require.NoError(t, err)
+		defer stmt.Close()
+
+		tx, err := database.Begin()
+		require.NoError(t, err)
+		defer tx.Rollback()
+
+		var name string
+		require.ErrorIs(t, database.QueryRow(`SELECT name FROM events WHERE id = ?`, 1).Scan(&name), sql.ErrNoRows)
+		require.ErrorIs(t, tx.QueryRow(`SELECT name FROM events WHERE id = ?`, 1).Scan(&name), sql.ErrNoRows)
+		require.ErrorIs(t, stmt.QueryRow(1).Scan(&name), sql.ErrNoRows)
+
+		require.Empty(t, *failed)
+		require.Empty(t, logger.find("error", "dbresolver: query failed"))
+
+		// the error is still reported on the query itself
+		rows := 0
+		for _, payload := range *after {
+			if event := payload.(dbresolver.AfterQueryEvent); event.Operation == dbresolver.OpQueryRow {
+				require.ErrorIs(t, event.Err, sql.ErrNoRows)
+				rows++
+			}
+		}
+
+		require.Equal(t, 3, rows)
+	})
+
+	t.Run("arg redaction policies", func(t *testing.T) {
+		logger := newRecordingLogger()
+		database := setupEventsDB(t, dbresolver.WithLogger(logger), dbresolver.WithArgRedaction(dbresolver.ShowArgs))
+
+		_, err := database.Query(`SELECT missing FROM events WHERE name = ?`, "secret")
+		require.Error(t, err)
+		require.Equal(t, []interface{}{"secret"}, logger.find("error", "dbresolver: query failed")[0].args["args"])
+
+		logger = newRecordingLogger()
+		database = setupEventsDB(t, dbresolver.WithLogger(logger), dbresolver.WithArgRedaction(dbresolver.OmitArgs))
+
+		_, err = database.Query(`SELECT missing FROM events WHERE name = ?`, "secret")
+		require.Error(t, err)
+		require.NotContains(t, logger.find("error", "dbresolver: query failed")[0].args, "args")
+	})
+
+	t.Run("health transitions", func(t *testing.T) {
+		logger := newRecordingLogger()
+		database := setupEventsDB(t, dbresolver.WithLogger(logger))
+
+		// nodes found up on their first check are not logged
+		require.NoError(t, database.CheckHealth(context.Background()))
+		require.Empty(t, logger.find("info", "dbresolver: node up"))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				require.NoError(t, database.CheckHealth(context.Background()))
+			}()
+		}
+		wg.Wait()
+
+		require.Empty(t, logger.find("info", "dbresolver: node up"))
+
+		// master and replica share the sqlite handle
+		require.NoError(t, database.Config.Replicas[0].Close())
+
+		require.Error(t, database.CheckHealth(context.Background()))
+		require.Len(t, logger.find("warn", "dbresolver: node down"), 2)
+	})
+
+	t.Run("node back up", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "missing")
+
+		logger := newRecordingLogger()
+		database, err := dbresolver.New(dbresolver.DBConfig{
+			Master: dbresolver.AsMaster(openDB(t, filepath.Join(dir, "health.db")), "write"),
+		}, dbresolver.WithLogger(logger))
+		require.NoError(t, err)
+
+		require.Error(t, database.CheckHealth(context.Background()))
+		require.Len(t, logger.find("warn", "dbresolver: node down"), 1)
+
+		require.NoError(t, os.Mkdir(dir, 0o755))
+
+		require.NoError(t, database.CheckHealth(context.Background()))
+		require.Len(t, logger.find("info", "dbresolver: node up"), 1)
+	})
+
+	t.Run("slow queries", func(t *testing.T) {
+		logger := newRecordingLogger()
+		database := setupEventsDB(t, dbresolver.WithSlowQueryLog(dbresolver.SlowQueryOpts{Threshold: 1, Logger: logger}))
+
+		_, err := database.Query(`SELECT name FROM events`)
+		require.NoError(t, err)
+
+		slow := logger.find("warn", "dbresolver: slow query")
+		require.Len(t, slow, 1)
+		require.Equal(t, "events_read", slow[0].args["node"])
+	})
+}
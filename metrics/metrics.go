@@ -0,0 +1,376 @@
+// Package metrics exports dbresolver metrics to Prometheus.
+//
+// Queries, errors and balancer picks are counted from the database hooks.
+// Koalescer counters, node health, replication lag and the sql.DBStats of
+// every node are read when the registry is scraped.
+//
+//	m := metrics.New(metrics.WithHealthCheck(time.Second))
+//	prometheus.MustRegister(m)
+//
+//	db, err := dbresolver.New(config,
+//		dbresolver.WithHooks(store),
+//		m.Instrument(),
+//	)
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultNamespace = "dbresolver"
+
+// Error classes used for the errors_total class label
+var (
+	ClassCanceled    = "canceled"
+	ClassTimeout     = "timeout"
+	ClassNoRows      = "no_rows"
+	ClassInvalidMode = "invalid_mode"
+	ClassBadConn     = "bad_conn"
+	ClassConnDone    = "conn_done"
+	ClassTxDone      = "tx_done"
+	ClassOther       = "other"
+)
+
+// ClassifyError maps err to one of the error classes.
+func ClassifyError(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ClassTimeout
+	case errors.Is(err, sql.ErrNoRows):
+		return ClassNoRows
+	case errors.Is(err, dbresolver.ErrorInvalidDBMode):
+		return ClassInvalidMode
+	case errors.Is(err, driver.ErrBadConn):
+		return ClassBadConn
+	case errors.Is(err, sql.ErrConnDone):
+		return ClassConnDone
+	case errors.Is(err, sql.ErrTxDone):
+		return ClassTxDone
+	}
+
+	return ClassOther
+}
+
+// LagFunc reports how far a replica is behind master.
+type LagFunc func(ctx context.Context, replica *dbresolver.ResolverDB) (time.Duration, error)
+
+type config struct {
+	namespace     string
+	buckets       []float64
+	classify      func(error) string
+	healthTimeout time.Duration
+	lag           LagFunc
+}
+
+type Option func(c *config)
+
+// WithNamespace sets the metric name prefix, dbresolver by default.
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithBuckets sets the latency histogram buckets, in seconds.
+func WithBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.buckets = buckets
+	}
+}
+
+// WithErrorClassifier replaces ClassifyError.
+func WithErrorClassifier(classify func(error) string) Option {
+	return func(c *config) {
+		c.classify = classify
+	}
+}
+
+// WithHealthCheck pings every node on scrape, and reports
+// the outcome in node_up. Each ping is bounded by timeout.
+func WithHealthCheck(timeout time.Duration) Option {
+	return func(c *config) {
+		c.healthTimeout = timeout
+	}
+}
+
+// WithReplicationLag reports the lag of every replica on scrape.
+// Replicas for which lag fails are left out.
+func WithReplicationLag(lag LagFunc) Option {
+	return func(c *config) {
+		c.lag = lag
+	}
+}
+
+// Metrics is a prometheus.Collector for one database.
+type Metrics struct {
+	config config
+
+	queries *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+	errors  *prometheus.CounterVec
+	picks   *prometheus.CounterVec
+
+	koalesceShared     *prometheus.Desc
+	koalesceSuppressed *prometheus.Desc
+	koalesceHits       *prometheus.Desc
+	koalesceMisses     *prometheus.Desc
+	koalesceInFlight   *prometheus.Desc
+
+	nodeUp     *prometheus.Desc
+	replicaLag *prometheus.Desc
+
+	poolMaxOpen           *prometheus.Desc
+	poolOpen              *prometheus.Desc
+	poolInUse             *prometheus.Desc
+	poolIdle              *prometheus.Desc
+	poolWaitCount         *prometheus.Desc
+	poolWaitDuration      *prometheus.Desc
+	poolMaxIdleClosed     *prometheus.Desc
+	poolMaxIdleTimeClosed *prometheus.Desc
+	poolMaxLifetimeClosed *prometheus.Desc
+
+	mu *sync.RWMutex
+	db *dbresolver.Database
+}
+
+func New(opts ...Option) *Metrics {
+	cfg := config{
+		namespace: defaultNamespace,
+		buckets:   prometheus.DefBuckets,
+		classify:  ClassifyError,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	name := func(name string) string {
+		return prometheus.BuildFQName(cfg.namespace, "", name)
+	}
+
+	desc := func(metric, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(name(metric), help, labels, nil)
+	}
+
+	nodeLabels := []string{"node", "role"}
+	queryLabels := []string{"node", "role", "operation"}
+
+	return &Metrics{
+		config: cfg,
+
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: name("queries_total"),
+			Help: "Statements run, by node, role and operation.",
+		}, queryLabels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    name("query_duration_seconds"),
+			Help:    "Statement latency, by node, role and operation.",
+			Buckets: cfg.buckets,
+		}, queryLabels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: name("errors_total"),
+			Help: "Failed statements, by node, role, operation and error class.",
+		}, append(queryLabels, "class")),
+		picks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: name("balancer_picks_total"),
+			Help: "Times a node was selected to run a statement.",
+		}, nodeLabels),
+
+		koalesceShared:     desc("koalescer_shared_results_total", "Results handed to a caller that did not run the query."),
+		koalesceSuppressed: desc("koalescer_duplicates_suppressed_total", "Callers that joined a query already in flight."),
+		koalesceHits:       desc("koalescer_cache_hits_total", "Koalescer cache hits."),
+		koalesceMisses:     desc("koalescer_cache_misses_total", "Koalescer cache misses."),
+		koalesceInFlight:   desc("koalescer_in_flight", "Coalesced queries currently running."),
+
+		nodeUp:     desc("node_up", "Whether the node answered the last ping.", nodeLabels...),
+		replicaLag: desc("replica_lag_seconds", "Replication lag of the replica.", "node"),
+
+		poolMaxOpen:           desc("pool_max_open_connections", "Maximum number of open connections.", nodeLabels...),
+		poolOpen:              desc("pool_open_connections", "Established connections, in use and idle.", nodeLabels...),
+		poolInUse:             desc("pool_in_use_connections", "Connections currently in use.", nodeLabels...),
+		poolIdle:              desc("pool_idle_connections", "Idle connections.", nodeLabels...),
+		poolWaitCount:         desc("pool_wait_count_total", "Connections waited for.", nodeLabels...),
+		poolWaitDuration:      desc("pool_wait_duration_seconds_total", "Time spent waiting for a connection.", nodeLabels...),
+		poolMaxIdleClosed:     desc("pool_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", nodeLabels...),
+		poolMaxIdleTimeClosed: desc("pool_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.", nodeLabels...),
+		poolMaxLifetimeClosed: desc("pool_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", nodeLabels...),
+
+		mu: &sync.RWMutex{},
+	}
+}
+
+// Instrument registers the metrics on the database being built,
+// once its options, including its hooks, were applied.
+func (m *Metrics) Instrument() dbresolver.DataBaseOpts {
+	return dbresolver.WithSetup(func(d *dbresolver.Database) {
+		m.Register(d)
+	})
+}
+
+// Register counts the queries and node selections of d, and reports
+// its nodes and koalescer on scrape. It returns the hook subscriptions.
+func (m *Metrics) Register(d *dbresolver.Database) []*hooks.Subscription {
+	m.mu.Lock()
+	m.db = d
+	m.mu.Unlock()
+
+	return []*hooks.Subscription{
+		d.Hooks.On(dbresolver.EventAfterQueryRun, m.onQuery),
+		d.Hooks.On(dbresolver.EventAfterDBSelect, m.onSelect),
+	}
+}
+
+func (m *Metrics) onQuery(payload interface{}) hooks.Result {
+	event, ok := payload.(dbresolver.AfterQueryEvent)
+	if !ok {
+		return hooks.Result{}
+	}
+
+	labels := []string{event.Node, string(event.Role), string(event.Operation)}
+
+	m.queries.WithLabelValues(labels...).Inc()
+	m.latency.WithLabelValues(labels...).Observe(event.Duration.Seconds())
+
+	// sql.ErrNoRows is an answer, not a failure
+	if event.Err != nil && !errors.Is(event.Err, sql.ErrNoRows) {
+		m.errors.WithLabelValues(append(labels, m.config.classify(event.Err))...).Inc()
+	}
+
+	return hooks.Result{}
+}
+
+func (m *Metrics) onSelect(payload interface{}) hooks.Result {
+	event, ok := payload.(dbresolver.AfterDBSelectEvent)
+	if !ok {
+		return hooks.Result{}
+	}
+
+	m.picks.WithLabelValues(event.Name, string(event.Role)).Inc()
+	return hooks.Result{}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.queries.Describe(ch)
+	m.latency.Describe(ch)
+	m.errors.Describe(ch)
+	m.picks.Describe(ch)
+
+	for _, desc := range []*prometheus.Desc{
+		m.koalesceShared, m.koalesceSuppressed, m.koalesceHits, m.koalesceMisses, m.koalesceInFlight,
+		m.nodeUp, m.replicaLag,
+		m.poolMaxOpen, m.poolOpen, m.poolInUse, m.poolIdle, m.poolWaitCount, m.poolWaitDuration,
+		m.poolMaxIdleClosed, m.poolMaxIdleTimeClosed, m.poolMaxLifetimeClosed,
+	} {
+		ch <- desc
+	}
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.queries.Collect(ch)
+	m.latency.Collect(ch)
+	m.errors.Collect(ch)
+	m.picks.Collect(ch)
+
+	m.mu.RLock()
+	db := m.db
+	m.mu.RUnlock()
+
+	if db == nil {
+		return
+	}
+
+	if koalescer := db.Koalescer(); koalescer != nil {
+		m.collectKoalescer(ch, koalescer)
+	}
+
+	nodes := db.Nodes()
+
+	for _, node := range nodes {
+		m.collectPool(ch, node)
+	}
+
+	m.collectHealth(ch, nodes)
+}
+
+func (m *Metrics) collectKoalescer(ch chan<- prometheus.Metric, koalescer *dbresolver.QueryKoalescer) {
+	stats := koalescer.Stats(0)
+
+	ch <- prometheus.MustNewConstMetric(m.koalesceShared, prometheus.CounterValue, float64(stats.SharedResults))
+	ch <- prometheus.MustNewConstMetric(m.koalesceSuppressed, prometheus.CounterValue, float64(stats.DuplicatesSuppressed))
+	ch <- prometheus.MustNewConstMetric(m.koalesceHits, prometheus.CounterValue, float64(stats.CacheHits))
+	ch <- prometheus.MustNewConstMetric(m.koalesceMisses, prometheus.CounterValue, float64(stats.CacheMisses))
+	ch <- prometheus.MustNewConstMetric(m.koalesceInFlight, prometheus.GaugeValue, float64(stats.InFlightKeys))
+}
+
+func (m *Metrics) collectPool(ch chan<- prometheus.Metric, node *dbresolver.ResolverDB) {
+	stats := node.Stats()
+	labels := []string{node.Name, string(node.Role())}
+
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+
+	counter := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, labels...)
+	}
+
+	gauge(m.poolMaxOpen, float64(stats.MaxOpenConnections))
+	gauge(m.poolOpen, float64(stats.OpenConnections))
+	gauge(m.poolInUse, float64(stats.InUse))
+	gauge(m.poolIdle, float64(stats.Idle))
+	counter(m.poolWaitCount, float64(stats.WaitCount))
+	counter(m.poolWaitDuration, stats.WaitDuration.Seconds())
+	counter(m.poolMaxIdleClosed, float64(stats.MaxIdleClosed))
+	counter(m.poolMaxIdleTimeClosed, float64(stats.MaxIdleTimeClosed))
+	counter(m.poolMaxLifetimeClosed, float64(stats.MaxLifetimeClosed))
+}
+
+// collectHealth pings the nodes and measures replica lag concurrently,
+// so that one slow node does not hold up the scrape for the others.
+func (m *Metrics) collectHealth(ch chan<- prometheus.Metric, nodes []*dbresolver.ResolverDB) {
+	if m.config.healthTimeout <= 0 && m.config.lag == nil {
+		return
+	}
+
+	wg := &sync.WaitGroup{}
+
+	for _, node := range nodes {
+		wg.Add(1)
+
+		go func(node *dbresolver.ResolverDB) {
+			defer wg.Done()
+
+			if m.config.healthTimeout > 0 {
+				ctx, cancel := context.WithTimeout(context.Background(), m.config.healthTimeout)
+				defer cancel()
+
+				up := 0.0
+				if node.PingContext(ctx) == nil {
+					up = 1
+				}
+
+				ch <- prometheus.MustNewConstMetric(m.nodeUp, prometheus.GaugeValue, up, node.Name, string(node.Role()))
+			}
+
+			if m.config.lag != nil && !node.IsMaster {
+				lag, err := m.config.lag(context.Background(), node)
+				if err == nil {
+					ch <- prometheus.MustNewConstMetric(m.replicaLag, prometheus.GaugeValue, lag.Seconds(), node.Name)
+				}
+			}
+		}(node)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,175 @@
+package metrics_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/go-batteries/dbresolver/metrics"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func setup(t *testing.T, m *metrics.Metrics, opts ...dbresolver.DataBaseOpts) *dbresolver.Database {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "../tmp/metrics.db")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS samples (id INTEGER PRIMARY KEY, name TEXT); DELETE FROM samples;`)
+	require.NoError(t, err)
+
+	// the hooks are set last, Instrument registers on them whatever its position
+	opts = append(opts, m.Instrument(), dbresolver.WithHooks(hooks.NewEventStore()))
+
+	return dbresolver.Register(dbresolver.DBConfig{
+		Master:   dbresolver.AsMaster(db, "write"),
+		Replicas: []*dbresolver.ResolverDB{dbresolver.AsSyncReplica(db, "read_a"), dbresolver.AsSyncReplica(db, "read_b")},
+	}, opts...)
+}
+
+func TestMetrics_Queries(t *testing.T) {
+	m := metrics.New()
+	database := setup(t, m)
+
+	_, err := database.WithMode(dbresolver.DbWriteMode).Exec(`INSERT INTO samples (name) VALUES ('a')`)
+	require.NoError(t, err)
+
+	_, err = database.Exec(`INSERT INTO samples (name) VALUES ('b')`)
+	require.ErrorIs(t, err, dbresolver.ErrorInvalidDBMode)
+
+	for i := 0; i < 3; i++ {
+		_, err = database.Query(`SELECT name FROM samples`)
+		require.NoError(t, err)
+	}
+
+	// no rows is counted as a query, not as an error
+	require.ErrorIs(t, database.QueryRow(`SELECT name FROM samples WHERE id = 0`).Err(), sql.ErrNoRows)
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(m))
+
+	expected := map[string]float64{
+		"dbresolver_queries_total{node=write,operation=exec,role=master}":             1,
+		"dbresolver_queries_total{node=,operation=exec,role=}":                        1,
+		"dbresolver_queries_total{node=read_a,operation=query,role=replica}":          2,
+		"dbresolver_queries_total{node=read_b,operation=query,role=replica}":          1,
+		"dbresolver_errors_total{class=invalid_mode,node=,operation=exec,role=}":      1,
+		"dbresolver_balancer_picks_total{node=write,role=master}":                     1,
+		"dbresolver_balancer_picks_total{node=read_a,role=replica}":                   2,
+		"dbresolver_query_duration_seconds{node=read_a,operation=query,role=replica}": 2,
+		"dbresolver_pool_max_open_connections{node=write,role=master}":                0,
+		"dbresolver_pool_wait_count_total{node=read_b,role=replica}":                  0,
+	}
+
+	values := gather(t, registry)
+	for name, value := range expected {
+		require.Contains(t, values, name)
+		require.Equal(t, value, values[name], name)
+	}
+
+	require.NotContains(t, values, "dbresolver_node_up{node=write,role=master}")
+	require.NotContains(t, values, "dbresolver_errors_total{class=no_rows,node=read_b,operation=query_row,role=replica}")
+	require.Equal(t, float64(1), values["dbresolver_queries_total{node=read_b,operation=query_row,role=replica}"])
+}
+
+func TestMetrics_Koalescer(t *testing.T) {
+	m := metrics.New()
+	koalescer := dbresolver.NewKoalescer(dbresolver.NewTimeEvictor(time.Minute), dbresolver.WithStaleWhileRevalidate(time.Minute, time.Minute))
+	database := setup(t, m, dbresolver.WithQueryQualescer(koalescer))
+
+	for i := 0; i < 3; i++ {
+		_, err := database.Query(`SELECT name FROM samples`)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 1, testutil.CollectAndCount(m, "dbresolver_koalescer_cache_hits_total"))
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(m))
+
+	values := gather(t, registry)
+	require.Equal(t, float64(2), values["dbresolver_koalescer_cache_hits_total"])
+	require.Equal(t, float64(1), values["dbresolver_koalescer_cache_misses_total"])
+	require.Equal(t, float64(0), values["dbresolver_koalescer_in_flight"])
+}
+
+func TestMetrics_Health(t *testing.T) {
+	m := metrics.New(
+		metrics.WithNamespace("app"),
+		metrics.WithHealthCheck(time.Second),
+		metrics.WithReplicationLag(func(ctx context.Context, replica *dbresolver.ResolverDB) (time.Duration, error) {
+			if replica.Name == "read_b" {
+				return 0, errors.New("unknown")
+			}
+
+			return 2 * time.Second, nil
+		}),
+	)
+	setup(t, m)
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(m))
+
+	values := gather(t, registry)
+	require.Equal(t, float64(1), values["app_node_up{node=write,role=master}"])
+	require.Equal(t, float64(1), values["app_node_up{node=read_b,role=replica}"])
+	require.Equal(t, float64(2), values["app_replica_lag_seconds{node=read_a}"])
+	require.NotContains(t, values, "app_replica_lag_seconds{node=read_b}")
+	require.NotContains(t, values, "app_replica_lag_seconds{node=write}")
+}
+
+func TestClassifyError(t *testing.T) {
+	require.Equal(t, metrics.ClassTimeout, metrics.ClassifyError(context.DeadlineExceeded))
+	require.Equal(t, metrics.ClassCanceled, metrics.ClassifyError(context.Canceled))
+	require.Equal(t, metrics.ClassTimeout, metrics.ClassifyError(dbresolver.ErrKoalesceTimeout))
+	require.Equal(t, metrics.ClassCanceled, metrics.ClassifyError(dbresolver.ErrKoalesceCancelled))
+	require.Equal(t, metrics.ClassNoRows, metrics.ClassifyError(sql.ErrNoRows))
+	require.Equal(t, metrics.ClassOther, metrics.ClassifyError(errors.New("boom")))
+}
+
+// gather flattens the registry into name{label=value,...} keys, with the
+// labels sorted by name. Histograms report their sample count.
+func gather(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name := family.GetName()
+
+			if len(metric.GetLabel()) > 0 {
+				name += "{"
+				for i, label := range metric.GetLabel() {
+					if i > 0 {
+						name += ","
+					}
+					name += label.GetName() + "=" + label.GetValue()
+				}
+				name += "}"
+			}
+
+			switch {
+			case metric.Counter != nil:
+				values[name] = metric.GetCounter().GetValue()
+			case metric.Gauge != nil:
+				values[name] = metric.GetGauge().GetValue()
+			case metric.Histogram != nil:
+				values[name] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	return values
+}
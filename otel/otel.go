@@ -0,0 +1,267 @@
+// Package otel traces dbresolver queries and transactions with OpenTelemetry.
+//
+// Every call going through the interceptor chain gets a span, carrying the
+// statement, operation, node, role, replica index, coalesced flag and rows.
+// Node selection and retries are recorded as span events. The span of a
+// QueryStream covers the opening of the stream, and ends before its rows
+// are read, so it carries no rows.
+//
+//	db, err := dbresolver.New(config,
+//		dbresolver.WithHooks(store),
+//		otel.Trace(otel.WithDBSystem("postgresql")),
+//	)
+package otel
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	otelglobal "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/go-batteries/dbresolver/otel"
+
+// Attribute keys, next to the db.* semantic conventions
+var (
+	DBSystemKey    = attribute.Key("db.system")
+	DBStatementKey = attribute.Key("db.statement")
+	DBOperationKey = attribute.Key("db.operation")
+
+	NodeNameKey     = attribute.Key("dbresolver.node.name")
+	NodeRoleKey     = attribute.Key("dbresolver.node.role")
+	ReplicaIndexKey = attribute.Key("dbresolver.replica.index")
+	CoalescedKey    = attribute.Key("dbresolver.coalesced")
+	RowsKey         = attribute.Key("dbresolver.rows")
+	InTxKey         = attribute.Key("dbresolver.in_tx")
+	TxEndKey        = attribute.Key("dbresolver.tx.end")
+	AttemptKey      = attribute.Key("dbresolver.attempt")
+)
+
+// Span event names
+var (
+	EventSelectDB = "dbresolver.select_db"
+	EventRetry    = "dbresolver.retry"
+)
+
+// DefaultSanitizer replaces string and numeric literals with ?, so that
+// values inlined in a statement do not end up in traces.
+var DefaultSanitizer = dbresolver.SanitizeStatement
+
+type config struct {
+	provider  trace.TracerProvider
+	sanitizer func(string) string
+	system    string
+}
+
+type Option func(c *config)
+
+// WithTracerProvider sets the provider spans are created from.
+// It defaults to the global provider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// WithStatementSanitizer sets how db.statement is recorded.
+// It defaults to DefaultSanitizer. A sanitizer returning an
+// empty string leaves db.statement out.
+func WithStatementSanitizer(sanitizer func(string) string) Option {
+	return func(c *config) {
+		c.sanitizer = sanitizer
+	}
+}
+
+// WithDBSystem sets db.system, e.g postgresql, mysql or sqlite.
+func WithDBSystem(system string) Option {
+	return func(c *config) {
+		c.system = system
+	}
+}
+
+type Tracer struct {
+	tracer trace.Tracer
+	config config
+}
+
+func New(opts ...Option) *Tracer {
+	cfg := config{sanitizer: DefaultSanitizer}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.provider == nil {
+		cfg.provider = otelglobal.GetTracerProvider()
+	}
+
+	return &Tracer{
+		tracer: cfg.provider.Tracer(instrumentationName),
+		config: cfg,
+	}
+}
+
+// Trace adds the tracing interceptor, at its position among the options,
+// and the node selection handler to the hooks the database ends up with.
+func Trace(opts ...Option) dbresolver.DataBaseOpts {
+	tracer := New(opts...)
+
+	return func(d *dbresolver.Database) {
+		dbresolver.WithInterceptors(tracer.Interceptor())(d)
+		dbresolver.WithSetup(func(d *dbresolver.Database) {
+			tracer.Register(d.Hooks)
+		})(d)
+	}
+}
+
+type txSpanKey struct{}
+
+// Interceptor starts a span per call. A transaction gets a span from
+// Begin to Commit or Rollback, with a child span for each of them.
+func (t *Tracer) Interceptor() dbresolver.Interceptor {
+	return func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+		switch info.Op {
+		case dbresolver.OpBegin:
+			return t.begin(ctx, info, next)
+		case dbresolver.OpCommit, dbresolver.OpRollback:
+			return t.finish(ctx, info, next)
+		}
+
+		return t.call(ctx, info, next)
+	}
+}
+
+func (t *Tracer) call(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+	ctx, span := t.tracer.Start(ctx, operation(info), trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	span.SetAttributes(t.attributes(info)...)
+
+	res, err := next(ctx, info)
+	t.record(span, info, res, err)
+
+	return res, err
+}
+
+// begin passes the transaction span on to next, instead of the BEGIN
+// span, since the transaction keeps the context it was started with.
+func (t *Tracer) begin(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+	ctx, span := t.tracer.Start(ctx, "transaction", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(t.attributes(info)...)
+
+	ctx = context.WithValue(ctx, txSpanKey{}, span)
+
+	_, beginSpan := t.tracer.Start(ctx, operation(info), trace.WithSpanKind(trace.SpanKindClient))
+	beginSpan.SetAttributes(t.attributes(info)...)
+
+	res, err := next(ctx, info)
+	t.record(beginSpan, info, res, err)
+	beginSpan.End()
+	if err != nil {
+		t.record(span, info, res, err)
+		span.End()
+	}
+
+	return res, err
+}
+
+func (t *Tracer) finish(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+	res, err := t.call(ctx, info, next)
+
+	if span, ok := ctx.Value(txSpanKey{}).(trace.Span); ok {
+		span.SetAttributes(TxEndKey.String(string(info.Op)))
+		t.record(span, info, res, err)
+		span.End()
+	}
+
+	return res, err
+}
+
+func (t *Tracer) attributes(info *dbresolver.QueryInfo) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		DBOperationKey.String(operation(info)),
+		InTxKey.Bool(info.InTx),
+	}
+
+	if t.config.system != "" {
+		attrs = append(attrs, DBSystemKey.String(t.config.system))
+	}
+
+	if stmt := t.config.sanitizer(info.Statement); stmt != "" {
+		attrs = append(attrs, DBStatementKey.String(stmt))
+	}
+
+	return attrs
+}
+
+func (t *Tracer) record(span trace.Span, info *dbresolver.QueryInfo, res dbresolver.QueryResult, err error) {
+	for _, retry := range info.Retries {
+		attrs := []attribute.KeyValue{AttemptKey.Int(retry.Attempt)}
+		if retry.Node != nil {
+			attrs = append(attrs, NodeNameKey.String(retry.Node.Name))
+		}
+
+		span.AddEvent(EventRetry, trace.WithTimestamp(retry.At), trace.WithAttributes(attrs...))
+	}
+
+	if info.Node != nil {
+		span.SetAttributes(
+			NodeNameKey.String(info.Node.Name),
+			NodeRoleKey.String(string(info.Node.Role())),
+		)
+	}
+
+	span.SetAttributes(
+		CoalescedKey.Bool(info.Coalesced),
+		RowsKey.Int64(res.RowCount()),
+	)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// Register records node selections as events on the span in the
+// hook context. It returns the subscription to remove it again.
+func (t *Tracer) Register(emitter hooks.EventEmitter) *hooks.Subscription {
+	return emitter.OnContext(dbresolver.EventAfterDBSelect, func(ctx context.Context, payload interface{}) hooks.Result {
+		event, ok := payload.(dbresolver.AfterDBSelectEvent)
+		if !ok {
+			return hooks.Result{}
+		}
+
+		span := trace.SpanFromContext(ctx)
+		if !span.IsRecording() {
+			return hooks.Result{}
+		}
+
+		attrs := []attribute.KeyValue{
+			NodeNameKey.String(event.Name),
+			NodeRoleKey.String(string(event.Role)),
+		}
+
+		if event.Role == dbresolver.RoleReplica {
+			attrs = append(attrs, ReplicaIndexKey.Int64(event.Index))
+			span.SetAttributes(ReplicaIndexKey.Int64(event.Index))
+		}
+
+		span.AddEvent(EventSelectDB, trace.WithAttributes(attrs...))
+		return hooks.Result{}
+	})
+}
+
+// operation is the first keyword of the statement, like SELECT
+func operation(info *dbresolver.QueryInfo) string {
+	fields := strings.Fields(info.Statement)
+	if len(fields) == 0 {
+		return strings.ToUpper(string(info.Op))
+	}
+
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,167 @@
+package otel_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/go-batteries/dbresolver/otel"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func setup(t *testing.T, opts ...dbresolver.DataBaseOpts) (*dbresolver.Database, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "../tmp/otel.db")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS spans (id INTEGER PRIMARY KEY, name TEXT); DELETE FROM spans;`)
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	// the hooks are set last, Trace registers on them whatever its position
+	opts = append(opts, otel.Trace(otel.WithTracerProvider(provider), otel.WithDBSystem("sqlite")))
+	opts = append(opts, dbresolver.WithHooks(hooks.NewEventStore()))
+
+	database := dbresolver.Register(dbresolver.DBConfig{
+		Master:   dbresolver.AsMaster(db, "write"),
+		Replicas: []*dbresolver.ResolverDB{dbresolver.AsSyncReplica(db, "read_a"), dbresolver.AsSyncReplica(db, "read_b")},
+	}, opts...)
+
+	return database, exporter
+}
+
+func attrs(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	values := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes {
+		values[kv.Key] = kv.Value
+	}
+
+	return values
+}
+
+func TestTrace_Query(t *testing.T) {
+	database, exporter := setup(t)
+
+	_, err := database.WithMode(dbresolver.DbWriteMode).Exec(`INSERT INTO spans (name) VALUES ('secret')`)
+	require.NoError(t, err)
+
+	_, err = database.Query(`SELECT name FROM spans WHERE id = 1`)
+	require.NoError(t, err)
+
+	_, err = database.Query(`SELECT name FROM spans`)
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+
+	insert := attrs(spans[0])
+	require.Equal(t, "INSERT", spans[0].Name)
+	require.Equal(t, "INSERT INTO spans (name) VALUES (?)", insert[otel.DBStatementKey].AsString())
+	require.Equal(t, "sqlite", insert[otel.DBSystemKey].AsString())
+	require.Equal(t, "write", insert[otel.NodeNameKey].AsString())
+	require.Equal(t, "master", insert[otel.NodeRoleKey].AsString())
+	require.Equal(t, int64(1), insert[otel.RowsKey].AsInt64())
+
+	first := attrs(spans[1])
+	require.Equal(t, "SELECT", first[otel.DBOperationKey].AsString())
+	require.Equal(t, "SELECT name FROM spans WHERE id = ?", first[otel.DBStatementKey].AsString())
+	require.Equal(t, "read_a", first[otel.NodeNameKey].AsString())
+	require.Equal(t, "replica", first[otel.NodeRoleKey].AsString())
+	require.Equal(t, int64(0), first[otel.ReplicaIndexKey].AsInt64())
+	require.False(t, first[otel.CoalescedKey].AsBool())
+
+	require.Len(t, spans[1].Events, 1)
+	require.Equal(t, otel.EventSelectDB, spans[1].Events[0].Name)
+
+	second := attrs(spans[2])
+	require.Equal(t, "read_b", second[otel.NodeNameKey].AsString())
+	require.Equal(t, int64(1), second[otel.ReplicaIndexKey].AsInt64())
+}
+
+func TestTrace_Errors(t *testing.T) {
+	database, exporter := setup(t)
+
+	_, err := database.Query(`SELECT missing FROM spans`)
+	require.Error(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	require.Equal(t, codes.Error, spans[0].Status.Code)
+}
+
+func TestTrace_Retries(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	attempts := 0
+
+	database, exporter := setup(t, dbresolver.WithInterceptors(
+		dbresolver.RetryInterceptor(3, func(err error) bool { return errors.Is(err, errFlaky) }),
+		func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+			attempts++
+			if attempts < 3 {
+				return dbresolver.QueryResult{}, errFlaky
+			}
+
+			return next(ctx, info)
+		},
+	))
+
+	_, err := database.Query(`SELECT name FROM spans`)
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+
+	retries := 0
+	for _, event := range spans[0].Events {
+		if event.Name == otel.EventRetry {
+			retries++
+		}
+	}
+
+	require.Equal(t, 2, retries)
+}
+
+func TestTrace_Transaction(t *testing.T) {
+	database, exporter := setup(t)
+
+	tx, err := database.Begin()
+	require.NoError(t, err)
+
+	_, err = tx.Exec(`INSERT INTO spans (name) VALUES ('a')`)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	spans := exporter.GetSpans()
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+
+	// spans are exported when they end
+	require.Equal(t, []string{"BEGIN", "INSERT", "COMMIT", "transaction"}, names)
+
+	txSpan := spans[3]
+	require.Equal(t, txSpan.SpanContext.SpanID(), spans[0].Parent.SpanID())
+	require.Equal(t, txSpan.SpanContext.SpanID(), spans[2].Parent.SpanID())
+	require.Equal(t, "commit", attrs(txSpan)[otel.TxEndKey].AsString())
+	require.True(t, attrs(spans[1])[otel.InTxKey].AsBool())
+}
+
+func TestDefaultSanitizer(t *testing.T) {
+	require.Equal(t,
+		"SELECT * FROM users WHERE name = ? AND age > ? AND users.v2 = ?",
+		otel.DefaultSanitizer("SELECT * FROM users WHERE name = 'O''Brien' AND age > 21.5 AND users.v2 = 3"),
+	)
+}
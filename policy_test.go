@@ -51,3 +51,23 @@ func TestPolicyResolver_RoundRobin(t *testing.T) {
 		require.Equal(t, expected, got)
 	})
 }
+
+func TestPolicyResolver_Weighted(t *testing.T) {
+	t.Run("picks in proportion to the weights", func(t *testing.T) {
+		wb := NewWeightedBalancer([]int{3, 1})
+
+		got := []int64{}
+		for i := 0; i < 8; i++ {
+			got = append(got, wb.Get())
+		}
+
+		require.Equal(t, []int64{0, 0, 1, 0, 0, 0, 1, 0}, got)
+	})
+
+	t.Run("weights below 1 count as 1", func(t *testing.T) {
+		wb := NewWeightedBalancer([]int{0, -2})
+
+		require.Equal(t, int64(0), wb.Get())
+		require.Equal(t, int64(1), wb.Get())
+	})
+}
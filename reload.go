@@ -0,0 +1,269 @@
+package dbresolver
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultDrainTimeout bounds how long Reload waits for removed
+	// nodes to finish their work, before closing them anyway.
+	DefaultDrainTimeout = 30 * time.Second
+
+	drainInterval = 10 * time.Millisecond
+)
+
+// cluster is the topology in use. Reload swaps its config while
+// holding mu, and node selection reads it while holding mu for read.
+type cluster struct {
+	mu     *sync.RWMutex
+	config DBConfig
+
+	// reloading serializes reloads
+	reloading *sync.Mutex
+}
+
+func newCluster(config DBConfig) *cluster {
+	return &cluster{
+		mu:        &sync.RWMutex{},
+		config:    config,
+		reloading: &sync.Mutex{},
+	}
+}
+
+func (rd *ResolverDB) acquire() {
+	atomic.AddInt64(&rd.active, 1)
+}
+
+func (rd *ResolverDB) release() {
+	atomic.AddInt64(&rd.active, -1)
+}
+
+// drain waits until no caller uses the node, or ctx is done
+func (rd *ResolverDB) drain(ctx context.Context) {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&rd.active) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CurrentConfig is the config in use, which differs
+// from Config once the database was reloaded.
+func (d *Database) CurrentConfig() DBConfig {
+	d.cluster.mu.RLock()
+	defer d.cluster.mu.RUnlock()
+
+	return d.cluster.config
+}
+
+// Nodes lists the nodes in use, master first.
+func (d *Database) Nodes() []*ResolverDB {
+	config := d.CurrentConfig()
+	return append([]*ResolverDB{config.Master}, config.Replicas...)
+}
+
+// TopologyReloadEvent lists the nodes changed by a reload, by name.
+// Updated nodes kept their name, but not their *sql.DB or settings.
+type TopologyReloadEvent struct {
+	Added   []string
+	Removed []string
+	Updated []string
+
+	Mode          DbActionMode
+	PolicyChanged bool
+}
+
+// Reload is ReloadContext with a background context.
+func (d *Database) Reload(config DBConfig) error {
+	return d.ReloadContext(context.Background(), config)
+}
+
+// ReloadContext swaps the topology for config, as New would build it.
+// Statements already running keep their node. Nodes no longer used are
+// drained, then closed, waiting at most until ctx is done, or for
+// DefaultDrainTimeout when ctx has no deadline.
+func (d *Database) ReloadContext(ctx context.Context, config DBConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	d.cluster.reloading.Lock()
+	defer d.cluster.reloading.Unlock()
+
+	previous := d.CurrentConfig()
+
+	config.Policy = newBalancer(config.Policy, len(config.Replicas))
+	if config.DefaultMode == nil {
+		config.DefaultMode = &DbReadMode
+	}
+
+	config.applyConnectionConfig()
+
+	event := diffTopology(previous, config)
+
+	d.cluster.mu.Lock()
+	d.cluster.config = config
+	d.cluster.mu.Unlock()
+
+	d.logger.Info("dbresolver: topology reloaded",
+		"added", event.Added,
+		"removed", event.Removed,
+		"updated", event.Updated,
+		"mode", string(event.Mode),
+	)
+	d.Hooks.EmitContext(ctx, EventTopologyReload, event)
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultDrainTimeout)
+		defer cancel()
+	}
+
+	for _, node := range unusedNodes(previous, config) {
+		node.drain(ctx)
+		node.Close()
+	}
+
+	return nil
+}
+
+func diffTopology(previous, next DBConfig) TopologyReloadEvent {
+	event := TopologyReloadEvent{
+		Mode:          *next.DefaultMode,
+		PolicyChanged: reflect.TypeOf(previous.Policy) != reflect.TypeOf(next.Policy),
+	}
+
+	before := nodesByName(previous)
+	after := nodesByName(next)
+
+	for _, node := range append([]*ResolverDB{next.Master}, next.Replicas...) {
+		old, ok := before[node.Name]
+
+		switch {
+		case !ok:
+			event.Added = append(event.Added, node.Name)
+		case old != node:
+			event.Updated = append(event.Updated, node.Name)
+		}
+	}
+
+	for _, node := range append([]*ResolverDB{previous.Master}, previous.Replicas...) {
+		if _, ok := after[node.Name]; !ok {
+			event.Removed = append(event.Removed, node.Name)
+		}
+	}
+
+	return event
+}
+
+func nodesByName(config DBConfig) map[string]*ResolverDB {
+	nodes := map[string]*ResolverDB{config.Master.Name: config.Master}
+	for _, replica := range config.Replicas {
+		nodes[replica.Name] = replica
+	}
+
+	return nodes
+}
+
+// unusedNodes are the previous nodes whose *sql.DB is not used by next.
+// Nodes renamed, or with new settings, may keep their *sql.DB.
+func unusedNodes(previous, next DBConfig) []*ResolverDB {
+	used := map[interface{}]bool{next.Master.DB: true}
+	for _, replica := range next.Replicas {
+		used[replica.DB] = true
+	}
+
+	unused := []*ResolverDB{}
+	for _, node := range append([]*ResolverDB{previous.Master}, previous.Replicas...) {
+		if !used[node.DB] {
+			used[node.DB] = true
+			unused = append(unused, node)
+		}
+	}
+
+	return unused
+}
+
+// ReloadCluster reloads from a config document. Nodes whose name, driver
+// and dsn are unchanged keep their connections, the others are opened.
+func (d *Database) ReloadCluster(ctx context.Context, c *ClusterConfig) error {
+	existing := nodesByName(d.CurrentConfig())
+
+	config, err := c.dbConfig(existing)
+	if err != nil {
+		return err
+	}
+
+	if err := d.ReloadContext(ctx, config); err != nil {
+		closeOpened(config, existing)
+		return err
+	}
+
+	return nil
+}
+
+// closeOpened closes the nodes of config not taken from existing
+func closeOpened(config DBConfig, existing map[string]*ResolverDB) {
+	reused := map[interface{}]bool{}
+	for _, node := range existing {
+		reused[node.DB] = true
+	}
+
+	for _, node := range append([]*ResolverDB{config.Master}, config.Replicas...) {
+		if node != nil && node.DB != nil && !reused[node.DB] {
+			node.Close()
+		}
+	}
+}
+
+// WatchConfigFile reloads the database from the config at path whenever
+// its content changes, checking every interval in the background, until
+// ctx is done. Failed reloads are logged, and keep the current topology.
+func (d *Database) WatchConfigFile(ctx context.Context, path string, interval time.Duration) error {
+	last, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil || bytes.Equal(content, last) {
+				continue
+			}
+
+			last = content
+
+			cfg, err := LoadConfigFile(path)
+			if err == nil {
+				err = d.ReloadCluster(ctx, cfg)
+			}
+
+			if err != nil {
+				d.logger.Error("dbresolver: reload failed", "path", path, "err", err)
+			}
+		}
+	}()
+
+	return nil
+}
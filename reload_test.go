@@ -0,0 +1,164 @@
+package dbresolver_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	t.Run("swaps the topology and emits the diff", func(t *testing.T) {
+		store := hooks.NewEventStore()
+		reloads := collect(store, dbresolver.EventTopologyReload)
+
+		master := dbresolver.AsMaster(openDB(t, "./testdbs/users_write.db"), "users_write")
+		readA := dbresolver.AsSyncReplica(openDB(t, "./testdbs/users_read_a.db"), "users_read_a")
+		readB := dbresolver.AsSyncReplica(openDB(t, "./testdbs/users_read_b.db"), "users_read_b")
+
+		database, err := dbresolver.New(dbresolver.DBConfig{
+			Master:   master,
+			Replicas: []*dbresolver.ResolverDB{readA},
+		}, dbresolver.WithHooks(store))
+		require.NoError(t, err)
+
+		writer := database.WithMode(dbresolver.DbWriteMode)
+
+		err = database.Reload(dbresolver.DBConfig{
+			Master:             master,
+			Replicas:           []*dbresolver.ResolverDB{readB},
+			DefaultMode:        &dbresolver.DbWriteMode,
+			MaxOpenConnections: dbresolver.ToPtr(7),
+		})
+		require.NoError(t, err)
+
+		require.Len(t, *reloads, 1)
+		event := (*reloads)[0].(dbresolver.TopologyReloadEvent)
+		require.Equal(t, []string{"users_read_b"}, event.Added)
+		require.Equal(t, []string{"users_read_a"}, event.Removed)
+		require.Empty(t, event.Updated)
+		require.Equal(t, dbresolver.DbWriteMode, event.Mode)
+
+		require.Equal(t, []*dbresolver.ResolverDB{master, readB}, database.Nodes())
+		require.Equal(t, 7, readB.Stats().MaxOpenConnections)
+		require.Equal(t, dbresolver.DbWriteMode, *database.CurrentConfig().DefaultMode)
+
+		// the removed replica was closed
+		require.Error(t, readA.Ping())
+
+		// copies made with WithMode follow the new topology
+		selected := collect(store, dbresolver.EventAfterDBSelect)
+		_, err = writer.Query(`SELECT 1`)
+		require.NoError(t, err)
+		_, err = writer.WithMode(dbresolver.DbReadMode).Query(`SELECT 1`)
+		require.NoError(t, err)
+
+		require.Equal(t, "users_write", (*selected)[0].(dbresolver.AfterDBSelectEvent).Name)
+		require.Equal(t, "users_read_b", (*selected)[1].(dbresolver.AfterDBSelectEvent).Name)
+	})
+
+	t.Run("invalid config keeps the topology", func(t *testing.T) {
+		database := setupEventsDB(t)
+		nodes := database.Nodes()
+
+		err := database.Reload(dbresolver.DBConfig{})
+		require.ErrorIs(t, err, dbresolver.ErrorNilMaster)
+		require.Equal(t, nodes, database.Nodes())
+	})
+
+	t.Run("waits for in flight transactions", func(t *testing.T) {
+		old := dbresolver.AsMaster(openDB(t, "./tmp/master.db"), "write")
+
+		database, err := dbresolver.New(dbresolver.DBConfig{Master: old})
+		require.NoError(t, err)
+
+		_, err = old.Exec(`CREATE TABLE IF NOT EXISTS test (id INTEGER PRIMARY KEY, name TEXT)`)
+		require.NoError(t, err)
+
+		tx, err := database.Begin()
+		require.NoError(t, err)
+
+		reloaded := make(chan error)
+		go func() {
+			reloaded <- database.Reload(dbresolver.DBConfig{
+				Master: dbresolver.AsMaster(openDB(t, "./tmp/replica.db"), "write"),
+			})
+		}()
+
+		select {
+		case <-reloaded:
+			t.Fatal("reload returned before the transaction ended")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		require.NotEqual(t, old, database.Nodes()[0])
+
+		_, err = tx.Exec(`INSERT INTO test (name) VALUES (?)`, "in-flight")
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+
+		require.NoError(t, <-reloaded)
+		require.Error(t, old.Ping())
+	})
+
+	t.Run("from a watched config file", func(t *testing.T) {
+		store := hooks.NewEventStore()
+		logger := newRecordingLogger()
+
+		path := filepath.Join(t.TempDir(), "cluster.yaml")
+		write := func(replica string) {
+			require.NoError(t, os.WriteFile(path, []byte(`
+driver: sqlite3
+master:
+  name: users_write
+  dsn: ./testdbs/users_write.db
+replicas:
+  - name: users_read
+    dsn: ./testdbs/`+replica+`.db
+`), 0o600))
+		}
+
+		write("users_read_a")
+
+		database, err := dbresolver.OpenFile(path, dbresolver.WithHooks(store), dbresolver.WithLogger(logger))
+		require.NoError(t, err)
+
+		master, replica := database.Nodes()[0], database.Nodes()[1]
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		require.NoError(t, database.WatchConfigFile(ctx, path, 10*time.Millisecond))
+
+		reloads := make(chan dbresolver.TopologyReloadEvent, 1)
+		store.On(dbresolver.EventTopologyReload, func(payload interface{}) hooks.Result {
+			reloads <- payload.(dbresolver.TopologyReloadEvent)
+			return hooks.Result{}
+		})
+
+		write("users_read_b")
+
+		select {
+		case event := <-reloads:
+			require.Equal(t, []string{"users_read"}, event.Updated)
+		case <-time.After(5 * time.Second):
+			t.Fatal("config change was not picked up")
+		}
+
+		// the master kept its connections, the replica was reopened
+		require.Eventually(t, func() bool { return replica.Ping() != nil }, time.Second, 10*time.Millisecond)
+		require.Same(t, master, database.Nodes()[0])
+		require.NotSame(t, replica, database.Nodes()[1])
+
+		write("users_read_b\n    weight: x")
+
+		require.Eventually(t, func() bool {
+			return len(logger.find("error", "dbresolver: reload failed")) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
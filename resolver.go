@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-batteries/dbresolver/hooks"
@@ -19,12 +20,23 @@ const (
 )
 
 type ResolverDB struct {
+	// active counts the callers using the node, so that Reload
+	// can drain it. It comes first to stay 64 bit aligned.
+	active int64
+	// health is what the last CheckHealth found, healthUnknown before
+	health uint32
+
 	*sql.DB
 
 	Name     string
 	IsMaster bool
 	InSync   bool
-	isUp     bool
+	// Zone is where the node runs, e.g an availability zone
+	Zone string
+
+	// source is what the node was opened with by a
+	// ClusterConfig, so that a reload can tell it is unchanged.
+	source nodeSource
 }
 
 func NewResolveDB(db *sql.DB, name string, isMaster, inSync bool) *ResolverDB {
@@ -53,11 +65,36 @@ func (rd *ResolverDB) UnWrap() *sql.DB {
 	return rd.DB
 }
 
+func (rd *ResolverDB) Role() NodeRole {
+	if rd.IsMaster {
+		return RoleMaster
+	}
+
+	return RoleReplica
+}
+
 func (rd *ResolverDB) CheckHealth(ctx context.Context) error {
+	_, err := rd.checkHealth(ctx)
+	return err
+}
+
+// Health of a node, unknown until it was checked once
+const (
+	healthUnknown uint32 = iota
+	healthUp
+	healthDown
+)
+
+// checkHealth pings the node, and returns the health it had before
+func (rd *ResolverDB) checkHealth(ctx context.Context) (uint32, error) {
 	err := rd.DB.PingContext(ctx)
-	rd.isUp = err == nil
 
-	return err
+	health := healthUp
+	if err != nil {
+		health = healthDown
+	}
+
+	return atomic.SwapUint32(&rd.health, health), err
 }
 
 type DBConfig struct {
@@ -70,31 +107,49 @@ type DBConfig struct {
 	ConnectionMaxLifetime *time.Duration
 }
 
+// New validates config, and builds the database. With WithStartupPing,
+// every node is pinged before returning.
+func New(config DBConfig, opts ...DataBaseOpts) (*Database, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	database := newDatabase(config, opts...)
+
+	if database.startupPing > 0 {
+		if err := database.pingAll(); err != nil {
+			return nil, err
+		}
+	}
+
+	return database, nil
+}
+
+// Register builds the database, checking only that master is set.
+//
+// Deprecated: Use New, which validates the config and
+// returns an error instead of exiting the process.
 func Register(config DBConfig, opts ...DataBaseOpts) *Database {
 	if config.Master == nil {
 		log.Fatal("config.Master db cannot be nil")
 	}
 
-	var balancer Balancer = NewRoundRobalancer(len(config.Replicas))
-
-	switch config.Policy.(type) {
-	case *RandomBalancer:
-		balancer = NewRoundRobalancer(len(config.Replicas))
-	case *RoundRobalancer:
-		balancer = NewRandomBalancer(len(config.Replicas))
-	default:
-		balancer = NewRoundRobalancer(len(config.Replicas))
-	}
+	return newDatabase(config, opts...)
+}
 
-	config.Policy = balancer
+func newDatabase(config DBConfig, opts ...DataBaseOpts) *Database {
+	config.Policy = newBalancer(config.Policy, len(config.Replicas))
 
 	if config.DefaultMode == nil {
 		config.DefaultMode = &DbReadMode
 	}
 
 	database := &Database{
-		Config: config,
-		Hooks:  hooks.NewEventStore(),
+		Config:  config,
+		Hooks:   hooks.NewEventStore(),
+		logger:  nopLogger{},
+		redact:  RedactArgs,
+		cluster: newCluster(config),
 	}
 
 	database.Config.applyConnectionConfig()
@@ -103,9 +158,41 @@ func Register(config DBConfig, opts ...DataBaseOpts) *Database {
 		opt(database)
 	}
 
+	if database.koalescer != nil && database.koalescer.hooks == nil {
+		database.koalescer.hooks = database.Hooks
+	}
+
+	for _, setup := range database.setups {
+		setup(database)
+	}
+
+	database.setups = nil
+
+	database.logger.Info("dbresolver: registered",
+		"master", config.Master.Name,
+		"replicas", len(config.Replicas),
+		"mode", string(*config.DefaultMode),
+	)
+
 	return database
 }
 
+// newBalancer sizes the built in balancers for the replicas.
+// Other balancers are used as they are.
+func newBalancer(policy Balancer, replicas int) Balancer {
+	switch policy.(type) {
+	case *RandomBalancer:
+		if replicas > 0 {
+			return NewRandomBalancer(replicas)
+		}
+	case nil, *RoundRobalancer:
+	default:
+		return policy
+	}
+
+	return NewRoundRobalancer(replicas)
+}
+
 func (cfg *DBConfig) applyConnectionConfig() {
 	SetConfigDefaults(cfg.Master.DB, cfg)
 
@@ -146,21 +233,29 @@ var (
 	DbReadMode  DbActionMode = "read"
 )
 
-var (
-	EventBeforeDBSelect string = "before::select_db"
-	EventAfterDBSelect  string = "after:select_db"
-	EventBeforeQueryRun string = "before::query_run"
-)
-
 var (
 	ErrorInvalidDBMode = errors.New("db mode invalid for query")
 	ErrorInvalidData   = errors.New("unexpected result type from query koalescer")
 )
 
+// Database routes statements to the nodes of its config.
+// Config is the config the database was built with. Once
+// Reload is used, CurrentConfig has the one in use.
 type Database struct {
-	Config    DBConfig
-	Hooks     hooks.EventEmitter
-	koalescer *QueryKoalescer
+	Config       DBConfig
+	Hooks        hooks.EventEmitter
+	koalescer    *QueryKoalescer
+	interceptors []Interceptor
+	logger       Logger
+	redact       ArgRedactor
+	startupPing  time.Duration
+
+	// cluster holds the current topology, shared with WithMode copies
+	cluster *cluster
+	// mode overrides the cluster default mode, for WithMode copies
+	mode *DbActionMode
+	// setups run once every option was applied
+	setups []func(d *Database)
 }
 
 type DataBaseOpts func(d *Database)
@@ -171,12 +266,25 @@ func WithQueryQualescer(koalescer *QueryKoalescer) DataBaseOpts {
 	}
 }
 
+// Koalescer is the query koalescer of the database, or nil.
+func (d *Database) Koalescer() *QueryKoalescer {
+	return d.koalescer
+}
+
 func WithHooks(eventHandler hooks.EventEmitter) DataBaseOpts {
 	return func(d *Database) {
 		d.Hooks = eventHandler
 	}
 }
 
+// WithSetup runs setup once every option was applied, whatever its
+// position, for options depending on others, e.g on the hooks.
+func WithSetup(setup func(d *Database)) DataBaseOpts {
+	return func(d *Database) {
+		d.setups = append(d.setups, setup)
+	}
+}
+
 func ToKey(stmt string, values ...interface{}) string {
 	hashed := HashValues(values...)
 	return fmt.Sprintf("%s_%s", stmt, hashed)
@@ -187,305 +295,371 @@ func (d *Database) WithMode(dbMode DbActionMode) *Database {
 	dbConfig.DefaultMode = &dbMode
 
 	nd := &Database{
-		Config: dbConfig,
-		Hooks:  d.Hooks,
+		Config:       dbConfig,
+		Hooks:        d.Hooks,
+		koalescer:    d.koalescer,
+		interceptors: d.interceptors,
+		logger:       d.logger,
+		redact:       d.redact,
+		cluster:      d.cluster,
+		mode:         &dbMode,
 	}
 
 	return nd
 }
 
 func (d *Database) Exec(stmt string, values ...interface{}) (sql.Result, error) {
-	d.Hooks.Emit(EventBeforeQueryRun, stmt, values)
+	return d.ExecContext(context.Background(), stmt, values...)
+}
 
-	if !isDML(strings.ToLower(stmt)) {
-		return d.selectSource().Exec(stmt, values...)
-	}
+func (d *Database) ExecContext(ctx context.Context, stmt string, values ...interface{}) (sql.Result, error) {
+	info := &QueryInfo{Op: OpExec, Statement: stmt, Args: values}
 
-	// If dml statement is not executed in write mode
-	// throw error
-	if !d.isWriteMode() {
-		return nil, ErrorInvalidDBMode
+	res, err := d.run(ctx, info, d.exec)
+	if err != nil {
+		return nil, err
 	}
 
-	defer func() {
-		if d.koalescer != nil {
-			d.koalescer.Forget(ToKey(stmt, values...))
-		}
-	}()
-
-	return d.getMaster().Exec(stmt, values...)
+	return res.Result, nil
 }
 
-func (d *Database) ExecContext(ctx context.Context, stmt string, values ...interface{}) (sql.Result, error) {
-	d.Hooks.Emit(EventBeforeQueryRun, stmt, values)
+func (d *Database) exec(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+	stmt, values := info.Statement, info.Args
+	prepared := info.prepared()
 
 	if !isDML(strings.ToLower(stmt)) {
-		return d.selectSource().ExecContext(ctx, stmt, values...)
+		info.Node = d.selectSource(ctx)
+		defer info.Node.release()
+
+		result, err := execNode(ctx, info.Node, prepared, stmt, values)
+		return QueryResult{Result: result}, err
 	}
 
+	// If dml statement is not executed in write mode
+	// throw error
 	if !d.isWriteMode() {
-		return nil, ErrorInvalidDBMode
+		return QueryResult{}, ErrorInvalidDBMode
 	}
 
+	// Only the key of the write itself is forgotten, the cached
+	// reads of the tables it changes are kept until their TTL.
 	defer func() {
 		if d.koalescer != nil {
-			d.koalescer.ForgetWithContext(ctx, ToKey(stmt, values...))
+			d.koalescer.Forget(ToKey(stmt, values...))
 		}
 	}()
 
-	return d.getMaster().ExecContext(ctx, stmt, values...)
+	info.Node = d.getMaster(ctx)
+	defer info.Node.release()
+
+	result, err := execNode(ctx, info.Node, prepared, stmt, values)
+	return QueryResult{Result: result}, err
 }
 
 func (d *Database) Query(stmt string, values ...interface{}) (Rows, error) {
-	d.Hooks.Emit(EventBeforeQueryRun, stmt, values)
-
-	source := d.selectSource()
-	key := ""
+	return d.QueryContext(context.Background(), stmt, values...)
+}
 
-	if d.koalescer != nil {
-		key = ToKey(stmt, values...)
+func (d *Database) QueryContext(ctx context.Context, stmt string, values ...interface{}) (Rows, error) {
+	result, err := d.queryResultSet(ctx, &QueryInfo{Op: OpQuery, Statement: stmt, Args: values})
+	if err != nil {
+		return nil, err
 	}
 
-	if isDML(strings.ToLower(stmt)) {
-		source = d.getMaster()
+	return result.Rows, nil
+}
 
-		if d.koalescer != nil {
-			d.koalescer.Forget(key)
-		}
-	}
+func (d *Database) QueryResultSet(stmt string, values ...interface{}) (*ResultSet, error) {
+	return d.QueryResultSetContext(context.Background(), stmt, values...)
+}
 
-	if d.koalescer == nil {
-		res, err := source.Query(stmt, values...)
-		if err != nil {
-			return nil, err
-		}
+// QueryResultSetContext runs a query like QueryContext, keeping the columns.
+// The result set may be shared with other callers, it must not be modified.
+func (d *Database) QueryResultSetContext(ctx context.Context, stmt string, values ...interface{}) (*ResultSet, error) {
+	return d.queryResultSet(ctx, &QueryInfo{Op: OpQuery, Statement: stmt, Args: values})
+}
 
-		return ToRows(res)
-	}
+func (d *Database) queryResultSet(ctx context.Context, info *QueryInfo) (*ResultSet, error) {
+	var result *ResultSet
 
-	resultCh := d.koalescer.DoChan(key, func() (interface{}, error) {
-		res, err := source.Query(stmt, values...)
+	res, err := d.run(ctx, info, func(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+		val, err := d.query(ctx, info, "", func(rows *sql.Rows) (interface{}, error) {
+			return ToResultSet(rows)
+		})
 		if err != nil {
-			return nil, err
+			return QueryResult{}, err
 		}
 
-		return ToRows(res)
-	})
-
-	result := <-resultCh
+		shared, ok := val.(*ResultSet)
+		if !ok {
+			return QueryResult{}, ErrorInvalidData
+		}
 
-	if result.Err != nil {
-		// res, err := source.Query(stmt, values...)
-		// if err != nil {
-		// 	return nil, err
-		// }
-		//
-		// return ToRows(res)
-		return nil, result.Err
+		result = shared
+		return QueryResult{Rows: shared.Rows}, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	rows, ok := result.Val.(Rows)
-	if !ok {
-		return nil, fmt.Errorf("unexpected result type from query koalescer")
+	// an interceptor may have answered with its own rows
+	if result == nil || !sameRows(result.Rows, res.Rows) {
+		return &ResultSet{Rows: res.Rows}, nil
 	}
 
-	return rows, nil
+	return result, nil
 }
 
-func (d *Database) QueryContext(ctx context.Context, stmt string, values ...interface{}) (Rows, error) {
-	d.Hooks.Emit(EventBeforeQueryRun, stmt, values)
-
-	source := d.selectSource()
-	key := ""
+func (d *Database) QueryRow(stmt string, values ...interface{}) *ResultRow {
+	return d.QueryRowContext(context.Background(), stmt, values...)
+}
 
-	if d.koalescer != nil {
-		key = ToKey(stmt, values...)
-	}
+// QueryRowContext runs a query expected to return at most one row.
+// Errors are deferred to the Scan or Values of the row, which
+// returns sql.ErrNoRows when there is no row.
+func (d *Database) QueryRowContext(ctx context.Context, stmt string, values ...interface{}) *ResultRow {
+	return d.queryRow(ctx, &QueryInfo{Op: OpQueryRow, Statement: stmt, Args: values})
+}
 
-	if isDML(strings.ToLower(stmt)) {
-		source = d.getMaster()
+func (d *Database) queryRow(ctx context.Context, info *QueryInfo) *ResultRow {
+	var record *Record
 
-		if d.koalescer != nil {
-			d.koalescer.ForgetWithContext(ctx, key)
+	res, err := d.run(ctx, info, func(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+		val, err := d.query(ctx, info, rowNamespace, func(rows *sql.Rows) (interface{}, error) {
+			return ToRecord(rows)
+		})
+		if err != nil {
+			return QueryResult{}, err
 		}
-	}
 
-	if d.koalescer == nil {
-		res, err := source.QueryContext(ctx, stmt, values...)
-		if err != nil {
-			return nil, err
+		shared, ok := val.(*Record)
+		if !ok {
+			return QueryResult{}, ErrorInvalidData
 		}
 
-		return ToRows(res)
-	}
+		record = shared
+		return QueryResult{Row: &shared.Values}, nil
+	})
 
-	resultCh := d.koalescer.DoWithContext(ctx, key, func() (interface{}, error) {
-		res, err := source.QueryContext(ctx, stmt, values...)
-		if err != nil {
-			return nil, err
-		}
+	return rowOf(record, res, err)
+}
 
-		return ToRows(res)
+// run passes info through the interceptor chain down to terminal,
+// and emits the query events around it. An opened stream emits
+// EventAfterQueryRun itself, once it is closed.
+func (d *Database) run(ctx context.Context, info *QueryInfo, terminal QueryFunc) (QueryResult, error) {
+	info.Mode = d.defaultMode()
+
+	d.Hooks.EmitContext(ctx, EventBeforeQueryRun, BeforeQueryEvent{
+		Operation: info.Op,
+		Statement: info.Statement,
+		Args:      info.Args,
+		Prepared:  info.Prepared,
 	})
 
-	result := <-resultCh
-	if result.Err != nil {
-		// res, err := source.QueryContext(ctx, stmt, values...)
-		// if err != nil {
-		// 	return nil, err
-		// }
-		// return ToRows(res)
-		return nil, result.Err
+	start := time.Now()
+	res, err := chain(d.interceptors, terminal)(ctx, info)
+	if err == nil && res.Stream != nil {
+		return res, nil
 	}
 
-	rows, ok := result.Val.(Rows)
-	if !ok {
-		return nil, ErrorInvalidData
+	event := AfterQueryEvent{
+		Operation: info.Op,
+		Statement: info.Statement,
+		Args:      info.Args,
+		Duration:  time.Since(start),
+		Rows:      res.RowCount(),
+		Err:       err,
+		Coalesced: info.Coalesced,
+		Prepared:  info.Prepared,
 	}
 
-	return rows, nil
+	if info.Node != nil {
+		event.Node, event.Role = info.Node.Name, info.Node.Role()
+	}
+
+	d.emitAfterQuery(ctx, event)
+
+	return res, err
+}
+
+// rowNamespace keeps the *Record of QueryRow apart from result sets
+const rowNamespace = "row:"
+
+// fetched is what a query shares through the koalescer,
+// so that every waiter can report the node it ran on.
+type fetched struct {
+	val  interface{}
+	node *ResolverDB
 }
 
-func (d *Database) QueryRow(stmt string, values ...interface{}) (*Row, error) {
-	d.Hooks.Emit(EventBeforeQueryRun, stmt, values)
+// query runs the statement on the selected source and materializes the
+// result with scan. Reads allowed by the koalescer rules are shared between
+// concurrent callers, and cached when the koalescer is set up to. Callers
+// scanning into another type than Rows or *Row pass their own namespace,
+// so that they never share results of another type.
+func (d *Database) query(
+	ctx context.Context,
+	info *QueryInfo,
+	namespace string,
+	scan func(*sql.Rows) (interface{}, error),
+) (interface{}, error) {
+	stmt, values := info.Statement, info.Args
+	prepared := info.prepared()
 
-	source := d.selectSource()
 	key := ""
 
 	if d.koalescer != nil {
 		key = ToKey(stmt, values...)
 	}
 
-	if isDML(strings.ToLower(stmt)) {
-		source = d.getMaster()
+	isWrite := isDML(strings.ToLower(stmt))
+	if isWrite && d.koalescer != nil {
+		d.koalescer.ForgetWithContext(ctx, key)
+	}
 
-		if d.koalescer != nil {
-			d.koalescer.Forget(key)
+	key = namespace + key
+
+	// The node is held by the call running the statement, which
+	// outlives the caller when it is shared with other waiters,
+	// or refreshes the cache in the background.
+	run := func(ctx context.Context) (interface{}, error) {
+		source := d.selectSource(ctx)
+		if isWrite {
+			source.release()
+			source = d.getMaster(ctx)
 		}
-	}
 
-	if d.koalescer == nil {
-		res, err := source.Query(stmt, values...)
+		defer source.release()
+
+		res, err := queryNode(ctx, source, prepared, stmt, values)
 		if err != nil {
-			return nil, err
+			return fetched{node: source}, err
 		}
 
-		return ToRow(res)
+		val, err := scan(res)
+		return fetched{val: val, node: source}, err
 	}
 
-	resultCh := d.koalescer.DoChan(key, func() (interface{}, error) {
-		res, err := source.Query(stmt, values...)
-		if err != nil {
-			return nil, err
-		}
+	// Writes are never shared or cached
+	if d.koalescer == nil || isWrite || !d.koalescer.Allows(ctx, stmt) {
+		res, err := run(ctx)
+		info.Node = res.(fetched).node
 
-		return ToRow(res)
-	})
+		return res.(fetched).val, err
+	}
+
+	result := <-d.koalescer.DoWithContext(ctx, key, run)
+	info.Coalesced = result.Shared
+
+	// The waiter may have given up before the query finished,
+	// then there is no result, nor node.
+	res, ok := result.Val.(fetched)
+	if ok && res.node != nil {
+		info.Node = res.node
+	}
 
-	result := <-resultCh
 	if result.Err != nil {
-		// res, err := source.Query(stmt, values...)
-		// if err != nil {
-		// 	return nil, err
-		// }
-		//
-		// return ToRow(res)
 		return nil, result.Err
 	}
 
-	row, ok := result.Val.(*Row)
 	if !ok {
 		return nil, ErrorInvalidData
 	}
 
-	return row, nil
+	return res.val, nil
 }
 
-func (d *Database) QueryRowContext(ctx context.Context, stmt string, values ...interface{}) (*Row, error) {
-	d.Hooks.Emit(EventBeforeQueryRun, stmt, values)
-
-	source := d.selectSource()
-	key := ""
+func (d *Database) emitAfterQuery(ctx context.Context, event AfterQueryEvent) {
+	d.Hooks.EmitContext(ctx, EventAfterQueryRun, event)
 
-	if d.koalescer != nil {
-		key = ToKey(stmt, values...)
+	// a query returning no row has not failed
+	if event.Err != nil && !errors.Is(event.Err, sql.ErrNoRows) {
+		d.Hooks.EmitContext(ctx, EventQueryError, event)
+		d.logQueryError(event)
 	}
+}
 
-	if isDML(strings.ToLower(stmt)) {
-		source = d.getMaster()
+// getReplica, getMaster and selectSource return the node acquired,
+// callers release it once done with it.
+func (d *Database) getReplica(ctx context.Context) (db *ResolverDB) {
+	d.cluster.mu.RLock()
+	config := d.cluster.config
+	nextIdx := config.Policy.Get()
 
-		if d.koalescer != nil {
-			d.koalescer.ForgetWithContext(ctx, key)
-		}
+	db = config.Master
+
+	if len(config.Replicas) > 0 && nextIdx < int64(len(config.Replicas)) {
+		db = config.Replicas[nextIdx]
 	}
 
-	if d.koalescer == nil {
-		res, err := source.QueryContext(ctx, stmt, values...)
-		if err != nil {
-			return nil, err
-		}
+	db.acquire()
+	d.cluster.mu.RUnlock()
 
-		return ToRow(res)
-	}
+	d.logger.Debug("dbresolver: selected node", "node", db.Name, "role", string(db.Role()), "index", nextIdx)
+	d.Hooks.EmitContext(ctx, EventAfterDBSelect, AfterDBSelectEvent{Role: db.Role(), Name: db.Name, Zone: db.Zone, Index: nextIdx})
+	return
+}
 
-	resultCh := d.koalescer.DoWithContext(ctx, key, func() (interface{}, error) {
-		res, err := source.QueryContext(ctx, stmt, values...)
-		if err != nil {
-			return nil, err
-		}
+func (d *Database) getMaster(ctx context.Context) *ResolverDB {
+	master := d.acquireMaster()
 
-		return ToRow(res)
-	})
+	d.logger.Debug("dbresolver: selected node", "node", master.Name, "role", string(RoleMaster))
+	d.Hooks.EmitContext(ctx, EventAfterDBSelect, AfterDBSelectEvent{Role: RoleMaster, Name: master.Name, Zone: master.Zone})
+	return master
+}
 
-	result := <-resultCh
-	if result.Err != nil {
-		// res, err := source.QueryContext(ctx, stmt, values...)
-		// if err != nil {
-		// 	return nil, err
-		// }
-		//
-		// return ToRow(res)
-		return nil, result.Err
-	}
+// acquireMaster returns master acquired, without the selection events
+func (d *Database) acquireMaster() *ResolverDB {
+	d.cluster.mu.RLock()
+	defer d.cluster.mu.RUnlock()
 
-	row, ok := result.Val.(*Row)
-	if !ok {
-		return nil, ErrorInvalidData
-	}
+	master := d.cluster.config.Master
+	master.acquire()
 
-	return row, nil
+	return master
 }
 
-func (d *Database) getReplica() (db *ResolverDB) {
-	nextIdx := d.Config.Policy.Get()
+// acquireNodes returns the master and the replicas acquired,
+// callers release each of them once done with it.
+func (d *Database) acquireNodes() []*ResolverDB {
+	d.cluster.mu.RLock()
+	defer d.cluster.mu.RUnlock()
 
-	db = d.Config.Master
+	config := d.cluster.config
+	nodes := append([]*ResolverDB{config.Master}, config.Replicas...)
 
-	if len(d.Config.Replicas) > 0 && nextIdx < int64(len(d.Config.Replicas)) {
-		db = d.Config.Replicas[nextIdx]
+	for _, node := range nodes {
+		node.acquire()
 	}
 
-	d.Hooks.Emit(EventAfterDBSelect, "replica", db.Name, nextIdx)
-	return
-}
-
-func (d *Database) getMaster() *ResolverDB {
-	d.Hooks.Emit(EventAfterDBSelect, "master", d.Config.Master.Name, 0)
-	return d.Config.Master
+	return nodes
 }
 
-func (d *Database) selectSource() *ResolverDB {
-	d.Hooks.Emit(EventBeforeDBSelect, *d.Config.DefaultMode)
+func (d *Database) selectSource(ctx context.Context) *ResolverDB {
+	mode := d.defaultMode()
+	d.Hooks.EmitContext(ctx, EventBeforeDBSelect, BeforeDBSelectEvent{Mode: mode})
 
-	if DbWriteMode == *d.Config.DefaultMode {
-		return d.getMaster()
+	if DbWriteMode == mode {
+		return d.getMaster(ctx)
 	}
 
-	return d.getReplica()
+	return d.getReplica(ctx)
 }
 
 func (d *Database) isWriteMode() bool {
-	return DbWriteMode == *d.Config.DefaultMode
+	return DbWriteMode == d.defaultMode()
+}
+
+func (d *Database) defaultMode() DbActionMode {
+	if d.mode != nil {
+		return *d.mode
+	}
+
+	d.cluster.mu.RLock()
+	defer d.cluster.mu.RUnlock()
+
+	return *d.cluster.config.DefaultMode
 }
 
 func isDML(sql string) bool {
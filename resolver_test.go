@@ -1,8 +1,11 @@
 package dbresolver
 
 import (
+	"context"
 	"database/sql"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
@@ -88,7 +91,7 @@ func TestExec_WriteMode(t *testing.T) {
 		t.Fatalf("expected row in master")
 	}
 
-	replicaRows, err := db.getReplica().DB.Query("SELECT name FROM test")
+	replicaRows, err := db.getReplica(context.Background()).DB.Query("SELECT name FROM test")
 	if err != nil {
 		t.Fatalf("failed to execute query from replica")
 	}
@@ -106,7 +109,7 @@ func TestQuery_ReadMode(t *testing.T) {
 	}
 
 	// Insert data directly into replica for testing
-	db.getReplica().DB.Exec("INSERT INTO test (name) VALUES (?)", "read-test")
+	db.getReplica(context.Background()).DB.Exec("INSERT INTO test (name) VALUES (?)", "read-test")
 
 	rows, err := db.Query("SELECT name FROM test")
 	if err != nil {
@@ -168,3 +171,31 @@ func TestDBModeSwitch(t *testing.T) {
 		t.Fatalf("expected 2 rows in master")
 	}
 }
+
+func TestQuery_SharedCallHoldsNode(t *testing.T) {
+	db, err := setupTestDB()
+	require.NoError(t, err)
+
+	db.koalescer = NewKoalescer(&NoopEvictor{})
+	replica := db.Config.Replicas[0]
+
+	release := make(chan struct{})
+	scan := func(rows *sql.Rows) (interface{}, error) {
+		<-release
+		return ToRows(rows)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// the waiter gives up, the statement keeps running on the replica
+	_, err = db.query(ctx, &QueryInfo{Op: OpQuery, Statement: "SELECT name FROM test"}, "", scan)
+	require.ErrorIs(t, err, ErrKoalesceTimeout)
+	require.Equal(t, int64(1), atomic.LoadInt64(&replica.active))
+
+	close(release)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&replica.active) == 0
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,250 @@
+package dbresolver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var ErrorScanDest = errors.New("unsupported scan destination")
+
+// ResultRow is the result of QueryRow, scanned like a *sql.Row.
+// Its error is deferred until Scan or Values is called.
+type ResultRow struct {
+	row     *Row
+	columns Columns
+	err     error
+}
+
+// newResultRow keeps the error of the query. An interceptor
+// answering without a row gets sql.ErrNoRows.
+func newResultRow(row *Row, columns Columns, err error) *ResultRow {
+	if err == nil && row == nil {
+		err = sql.ErrNoRows
+	}
+
+	return &ResultRow{row: row, columns: columns, err: err}
+}
+
+// rowOf keeps the columns of record, unless an interceptor
+// answered with a row of its own, which has none.
+func rowOf(record *Record, res QueryResult, err error) *ResultRow {
+	if record == nil || res.Row != &record.Values {
+		return newResultRow(res.Row, nil, err)
+	}
+
+	return newResultRow(res.Row, record.Columns, err)
+}
+
+// Err is the error of the query, sql.ErrNoRows when it returned no row.
+func (r *ResultRow) Err() error {
+	return r.err
+}
+
+// Values returns the row as QueryRow used to return it, before
+// it was scannable. The *Row may be shared with other callers
+// through the koalescer, it must not be modified.
+func (r *ResultRow) Values() (*Row, error) {
+	return r.row, r.err
+}
+
+// Columns describes the columns of the row. It is nil when
+// the query failed, or for a row made by an interceptor.
+func (r *ResultRow) Columns() Columns {
+	if r.err != nil {
+		return nil
+	}
+
+	return r.columns
+}
+
+// Record is the row with its columns.
+func (r *ResultRow) Record() (Record, error) {
+	if r.err != nil {
+		return Record{}, r.err
+	}
+
+	return Record{Columns: r.columns, Values: *r.row}, nil
+}
+
+// Get returns the value of the column named name.
+func (r *ResultRow) Get(name string) (interface{}, bool) {
+	record, err := r.Record()
+	if err != nil {
+		return nil, false
+	}
+
+	return record.Get(name)
+}
+
+// Map returns the values keyed by column name, see Record.Map.
+func (r *ResultRow) Map() (map[string]interface{}, error) {
+	record, err := r.Record()
+	if err != nil {
+		return nil, err
+	}
+
+	return record.Map(), nil
+}
+
+// JSON encodes the row as an object keyed by column name.
+func (r *ResultRow) JSON() ([]byte, error) {
+	record, err := r.Record()
+	if err != nil {
+		return nil, err
+	}
+
+	return record.JSON()
+}
+
+// Scan copies the columns of the row into dest, converting them
+// like database/sql does for the common types: sql.Scanner, pointers
+// for nullable columns, strings, []byte, numbers, bool and time.Time.
+func (r *ResultRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	if len(dest) != len(*r.row) {
+		return fmt.Errorf("sql: expected %d destination arguments in Scan, not %d", len(*r.row), len(dest))
+	}
+
+	for i, value := range *r.row {
+		if err := assign(dest[i], value); err != nil {
+			return fmt.Errorf("sql: Scan error on column index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// assign stores src, a value read from a driver, into dest
+func assign(dest, src interface{}) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(src)
+	}
+
+	if target, ok := dest.(*interface{}); ok {
+		if text, ok := src.([]byte); ok {
+			src = append([]byte(nil), text...)
+		}
+
+		*target = src
+		return nil
+	}
+
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("%w: %T", ErrorScanDest, dest)
+	}
+
+	dv = dv.Elem()
+
+	// a pointer destination is set to nil for NULL
+	if dv.Kind() == reflect.Ptr {
+		if src == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+			return nil
+		}
+
+		value := reflect.New(dv.Type().Elem())
+		if err := assign(value.Interface(), src); err != nil {
+			return err
+		}
+
+		dv.Set(value)
+		return nil
+	}
+
+	if src == nil {
+		return fmt.Errorf("converting NULL to %s is unsupported", dv.Kind())
+	}
+
+	if text, ok := src.([]byte); ok {
+		switch dv.Kind() {
+		case reflect.String:
+			dv.SetString(string(text))
+			return nil
+		case reflect.Slice:
+			if dv.Type().Elem().Kind() == reflect.Uint8 {
+				dv.SetBytes(append([]byte(nil), text...))
+				return nil
+			}
+		}
+	}
+
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dv.Type()) {
+		dv.Set(sv)
+		return nil
+	}
+
+	text := asString(src)
+
+	switch dv.Kind() {
+	case reflect.String:
+		dv.SetString(text)
+		return nil
+	case reflect.Slice:
+		if dv.Type().Elem().Kind() == reflect.Uint8 {
+			dv.SetBytes([]byte(text))
+			return nil
+		}
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("converting %T to bool: %w", src, err)
+		}
+
+		dv.SetBool(parsed)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T to %s: %w", src, dv.Kind(), err)
+		}
+
+		dv.SetInt(parsed)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(text, 10, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T to %s: %w", src, dv.Kind(), err)
+		}
+
+		dv.SetUint(parsed)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(text, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T to %s: %w", src, dv.Kind(), err)
+		}
+
+		dv.SetFloat(parsed)
+		return nil
+	}
+
+	return fmt.Errorf("%w: converting %T to %s", ErrorScanDest, src, dv.Type())
+}
+
+func asString(src interface{}) string {
+	switch value := src.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	}
+
+	return fmt.Sprint(src)
+}
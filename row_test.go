@@ -0,0 +1,163 @@
+package dbresolver_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryRow(t *testing.T) {
+	t.Run("scans like database/sql", func(t *testing.T) {
+		database := setupTypedDB(t)
+
+		var (
+			id    int32
+			name  []byte
+			email *string
+			score sql.NullFloat64
+			value interface{}
+			text  string
+		)
+
+		err := database.QueryRow(`SELECT id, name, email, score, created_by, score FROM users WHERE id = ?`, 2).
+			Scan(&id, &name, &email, &score, &value, &text)
+		require.ErrorContains(t, err, "converting NULL to string is unsupported")
+
+		err = database.QueryRow(`SELECT id, name, email, score, created_by, id FROM users WHERE id = ?`, 2).
+			Scan(&id, &name, &email, &score, &value, &text)
+		require.NoError(t, err)
+
+		require.Equal(t, int32(2), id)
+		require.Equal(t, []byte("john"), name)
+		require.Nil(t, email)
+		require.False(t, score.Valid)
+		require.Equal(t, "import", value)
+		require.Equal(t, "2", text)
+
+		require.NoError(t, database.QueryRow(`SELECT email, score FROM users WHERE id = ?`, 1).Scan(&email, &score))
+		require.Equal(t, "jane@example.com", *email)
+		require.Equal(t, 4.5, score.Float64)
+	})
+
+	t.Run("no rows", func(t *testing.T) {
+		database := setupTypedDB(t)
+
+		var name string
+
+		row := database.QueryRow(`SELECT name FROM users WHERE id = ?`, 3)
+		require.ErrorIs(t, row.Scan(&name), sql.ErrNoRows)
+		require.ErrorIs(t, row.Err(), sql.ErrNoRows)
+
+		tx, err := database.Begin()
+		require.NoError(t, err)
+		defer tx.Rollback()
+
+		require.ErrorIs(t, tx.QueryRow(`SELECT name FROM users WHERE id = ?`, 3).Scan(&name), sql.ErrNoRows)
+		require.NoError(t, tx.QueryRow(`SELECT name FROM users WHERE id = ?`, 1).Scan(&name))
+		require.Equal(t, "jane", name)
+	})
+
+	t.Run("errors are deferred to scan", func(t *testing.T) {
+		database := setupTypedDB(t)
+
+		var name string
+
+		row := database.QueryRow(`SELECT missing FROM users`)
+		require.Error(t, row.Err())
+		require.Equal(t, row.Err(), row.Scan(&name))
+
+		err := database.QueryRow(`SELECT id, name FROM users WHERE id = ?`, 1).Scan(&name)
+		require.ErrorContains(t, err, "expected 2 destination arguments")
+
+		var id int8
+		err = database.QueryRow(`SELECT 1000`).Scan(&id)
+		require.ErrorContains(t, err, "value out of range")
+
+		err = database.QueryRow(`SELECT name FROM users WHERE id = ?`, 1).Scan(name)
+		require.ErrorIs(t, err, dbresolver.ErrorScanDest)
+	})
+
+	t.Run("values keep the *Row api", func(t *testing.T) {
+		database := setupTypedDB(t)
+
+		row, err := database.QueryRow(`SELECT id, name FROM users WHERE id = ?`, 1).Values()
+		require.NoError(t, err)
+		require.Equal(t, dbresolver.Row{int64(1), "jane"}, *row)
+
+		_, err = database.QueryRow(`SELECT id, name FROM users WHERE id = ?`, 3).Values()
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("keeps the columns of the row", func(t *testing.T) {
+		database := setupTypedDB(t, dbresolver.WithQueryQualescer(
+			dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour)),
+		))
+
+		stmt, err := database.Prepare(`SELECT id, name, email FROM users WHERE id = ?`)
+		require.NoError(t, err)
+		defer stmt.Close()
+
+		tx, err := database.Begin()
+		require.NoError(t, err)
+		defer tx.Rollback()
+
+		query := `SELECT id, name, email FROM users WHERE id = ?`
+		rows := map[string]*dbresolver.ResultRow{
+			"db":     database.QueryRow(query, 2),
+			"cached": database.QueryRow(query, 2),
+			"tx":     tx.QueryRow(query, 2),
+			"stmt":   stmt.QueryRow(2),
+		}
+
+		for from, row := range rows {
+			require.Equal(t, []string{"id", "name", "email"}, row.Columns().Names(), from)
+			require.Equal(t, "TEXT", row.Columns()[1].DatabaseType, from)
+
+			name, ok := row.Get("name")
+			require.True(t, ok, from)
+			require.Equal(t, "john", name, from)
+
+			_, ok = row.Get("missing")
+			require.False(t, ok, from)
+
+			values, err := row.Map()
+			require.NoError(t, err, from)
+			require.Equal(t, map[string]interface{}{"id": int64(2), "name": "john", "email": nil}, values, from)
+
+			encoded, err := row.JSON()
+			require.NoError(t, err, from)
+			require.JSONEq(t, `{"id": 2, "name": "john", "email": null}`, string(encoded), from)
+		}
+
+		missing := database.QueryRow(query, 3)
+		require.Nil(t, missing.Columns())
+
+		_, ok := missing.Get("name")
+		require.False(t, ok)
+
+		_, err = missing.Map()
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		_, err = missing.JSON()
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("rows made by interceptors have no columns", func(t *testing.T) {
+		database := setupTypedDB(t, dbresolver.WithInterceptors(
+			func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				return dbresolver.QueryResult{Row: &dbresolver.Row{"cached"}}, nil
+			},
+		))
+
+		row := database.QueryRow(`SELECT name FROM users WHERE id = ?`, 1)
+		require.Empty(t, row.Columns())
+
+		var name string
+		require.NoError(t, row.Scan(&name))
+		require.Equal(t, "cached", name)
+	})
+}
@@ -0,0 +1,428 @@
+package dbresolver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultSlowQueryThreshold = 500 * time.Millisecond
+	DefaultExplainTimeout     = 5 * time.Second
+)
+
+var (
+	stringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	// numericLiteral matches $N placeholders too, which are kept
+	numericLiteral = regexp.MustCompile(`\$?\b\d+(?:\.\d+)?\b`)
+)
+
+// SanitizeStatement replaces string and numeric literals with ?, so that
+// values inlined in a statement do not end up in logs or traces.
+// Placeholders, like $1, are left as they are.
+func SanitizeStatement(stmt string) string {
+	stmt = stringLiteral.ReplaceAllString(stmt, "?")
+
+	return numericLiteral.ReplaceAllStringFunc(stmt, func(literal string) string {
+		if strings.HasPrefix(literal, "$") {
+			return literal
+		}
+
+		return "?"
+	})
+}
+
+// SlowQuery is a statement that took longer than the threshold.
+type SlowQuery struct {
+	Operation Operation
+	// Statement is sanitized
+	Statement string
+	// ArgTypes describes the args without their values, e.g string(12)
+	ArgTypes []string
+	Node     string
+	Role     NodeRole
+	Duration time.Duration
+	Err      error
+	// Origin is the file:line of the first caller outside dbresolver,
+	// and database/sql, gorm or sqlx when called through them
+	Origin string
+
+	// Plan is the EXPLAIN output, one line per row,
+	// when SlowQueryOpts.Explain is set.
+	Plan    string
+	PlanErr error
+}
+
+func (sq SlowQuery) String() string {
+	msg := fmt.Sprintf("slow query: %s on %s (%s) took %s from %s: %s %v",
+		sq.Operation, sq.Node, sq.Role, sq.Duration, sq.Origin, sq.Statement, sq.ArgTypes)
+
+	if sq.Err != nil {
+		msg += fmt.Sprintf(" err=%v", sq.Err)
+	}
+
+	if sq.Plan != "" {
+		msg += "\n" + sq.Plan
+	}
+
+	return msg
+}
+
+func logSlowQuery(logger Logger) func(SlowQuery) {
+	return func(sq SlowQuery) {
+		args := []interface{}{
+			"op", string(sq.Operation),
+			"statement", sq.Statement,
+			"arg_types", sq.ArgTypes,
+			"node", sq.Node,
+			"role", string(sq.Role),
+			"duration", sq.Duration,
+			"origin", sq.Origin,
+		}
+
+		if sq.Err != nil {
+			args = append(args, "err", sq.Err)
+		}
+
+		if sq.Plan != "" || sq.PlanErr != nil {
+			args = append(args, "plan", sq.Plan, "plan_err", sq.PlanErr)
+		}
+
+		logger.Warn("dbresolver: slow query", args...)
+	}
+}
+
+type SlowQueryOpts struct {
+	// Threshold defaults to DefaultSlowQueryThreshold
+	Threshold time.Duration
+	// SampleEvery logs one in SampleEvery slow queries. 0 and 1 log all.
+	SampleEvery int
+	// RateLimit caps the number of slow queries logged per second,
+	// 0 means no limit. Bursts up to RateLimit are allowed.
+	RateLimit int
+
+	// Explain captures the query plan from the node the statement
+	// ran on, before logging. It runs in the background.
+	Explain bool
+	// ExplainPrefix defaults to EXPLAIN QUERY PLAN for sqlite,
+	// and EXPLAIN for other drivers.
+	ExplainPrefix  string
+	ExplainTimeout time.Duration
+
+	// Sanitize defaults to SanitizeStatement
+	Sanitize func(string) string
+	// Log receives the slow queries. When nil, they are logged to
+	// Logger at warn level, or to the standard logger.
+	Log    func(SlowQuery)
+	Logger Logger
+}
+
+// SlowQueryLog logs statements slower than a threshold, through its
+// Interceptor. Sampling and rate limiting keep a degraded database
+// from flooding the logs. A QueryStream is timed until it is opened,
+// the reading of its rows is left to the caller.
+type SlowQueryLog struct {
+	// the counters come first to stay 64 bit aligned
+	seen       uint64
+	suppressed uint64
+
+	opts SlowQueryOpts
+
+	limiter *rateLimiter
+	wg      *sync.WaitGroup
+}
+
+func NewSlowQueryLog(opts SlowQueryOpts) *SlowQueryLog {
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultSlowQueryThreshold
+	}
+
+	if opts.ExplainTimeout <= 0 {
+		opts.ExplainTimeout = DefaultExplainTimeout
+	}
+
+	if opts.Sanitize == nil {
+		opts.Sanitize = SanitizeStatement
+	}
+
+	if opts.Log == nil && opts.Logger != nil {
+		opts.Log = logSlowQuery(opts.Logger)
+	}
+
+	if opts.Log == nil {
+		opts.Log = func(sq SlowQuery) { log.Println(sq) }
+	}
+
+	sl := &SlowQueryLog{opts: opts, wg: &sync.WaitGroup{}}
+	if opts.RateLimit > 0 {
+		sl.limiter = newRateLimiter(opts.RateLimit)
+	}
+
+	return sl
+}
+
+// WithSlowQueryLog adds the interceptor of a new SlowQueryLog.
+func WithSlowQueryLog(opts SlowQueryOpts) DataBaseOpts {
+	return WithInterceptors(NewSlowQueryLog(opts).Interceptor())
+}
+
+func (sl *SlowQueryLog) Interceptor() Interceptor {
+	return func(ctx context.Context, info *QueryInfo, next QueryFunc) (QueryResult, error) {
+		start := time.Now()
+		res, err := next(ctx, info)
+
+		if duration := time.Since(start); duration >= sl.opts.Threshold {
+			sl.record(info, duration, err)
+		}
+
+		return res, err
+	}
+}
+
+// Suppressed is the number of slow queries left out
+// by sampling or rate limiting.
+func (sl *SlowQueryLog) Suppressed() uint64 {
+	return atomic.LoadUint64(&sl.suppressed)
+}
+
+// Flush waits for the pending EXPLAIN captures to be logged.
+func (sl *SlowQueryLog) Flush() {
+	sl.wg.Wait()
+}
+
+func (sl *SlowQueryLog) record(info *QueryInfo, duration time.Duration, err error) {
+	count := atomic.AddUint64(&sl.seen, 1)
+
+	sampled := sl.opts.SampleEvery <= 1 || count%uint64(sl.opts.SampleEvery) == 0
+	if !sampled || (sl.limiter != nil && !sl.limiter.allow()) {
+		atomic.AddUint64(&sl.suppressed, 1)
+		return
+	}
+
+	sq := SlowQuery{
+		Operation: info.Op,
+		Statement: sl.opts.Sanitize(info.Statement),
+		ArgTypes:  argTypes(info.Args),
+		Duration:  duration,
+		Err:       err,
+		Origin:    origin(),
+	}
+
+	if info.Node != nil {
+		sq.Node, sq.Role = info.Node.Name, info.Node.Role()
+	}
+
+	if !sl.opts.Explain || info.Node == nil || !explainable(info.Op) {
+		sl.opts.Log(sq)
+		return
+	}
+
+	node, stmt, args := info.Node, info.Statement, info.Args
+
+	// the statement released the node, hold it
+	// so that a reload does not close it meanwhile
+	node.acquire()
+
+	sl.wg.Add(1)
+	go func() {
+		defer sl.wg.Done()
+		defer node.release()
+
+		sq.Plan, sq.PlanErr = sl.explain(node, stmt, args)
+		sl.opts.Log(sq)
+	}()
+}
+
+func (sl *SlowQueryLog) explain(node *ResolverDB, stmt string, args []interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sl.opts.ExplainTimeout)
+	defer cancel()
+
+	prefix := sl.opts.ExplainPrefix
+	if prefix == "" {
+		prefix = explainPrefix(ctx, node)
+	}
+
+	rows, err := node.QueryContext(ctx, prefix+" "+stmt, args...)
+	if err != nil {
+		return "", err
+	}
+
+	plan, err := ToRows(rows)
+	if err != nil {
+		return "", err
+	}
+
+	lines := make([]string, 0, len(plan))
+	for _, row := range plan {
+		cells := make([]string, 0, len(*row))
+		for _, cell := range *row {
+			if b, ok := cell.([]byte); ok {
+				cell = string(b)
+			}
+
+			cells = append(cells, fmt.Sprint(cell))
+		}
+
+		lines = append(lines, strings.Join(cells, " | "))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func explainable(op Operation) bool {
+	return op == OpExec || op == OpQuery || op == OpQueryRow
+}
+
+func explainPrefix(ctx context.Context, node *ResolverDB) string {
+	if strings.Contains(strings.ToLower(driverType(ctx, node)), "sqlite") {
+		return "EXPLAIN QUERY PLAN"
+	}
+
+	return "EXPLAIN"
+}
+
+// driverType is the type name of the driver of node. The driver of a
+// RotatingConnector is only known from its connections, which wrap the
+// connections of the actual driver.
+func driverType(ctx context.Context, node *ResolverDB) string {
+	if _, ok := node.Driver().(rotatingDriver); !ok {
+		return fmt.Sprintf("%T", node.Driver())
+	}
+
+	conn, err := node.Conn(ctx)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	name := ""
+	conn.Raw(func(dc interface{}) error {
+		if rc, ok := dc.(*rotatingConn); ok {
+			dc = rc.Conn
+		}
+
+		name = fmt.Sprintf("%T", dc)
+		return nil
+	})
+
+	return name
+}
+
+// argTypes describes args by type, with the length of strings and bytes
+func argTypes(args []interface{}) []string {
+	types := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+			types = append(types, "nil")
+		case string:
+			types = append(types, fmt.Sprintf("string(%d)", len(v)))
+		case []byte:
+			types = append(types, fmt.Sprintf("[]byte(%d)", len(v)))
+		case sql.NamedArg:
+			types = append(types, fmt.Sprintf("%s:%s", v.Name, argTypes([]interface{}{v.Value})[0]))
+		default:
+			types = append(types, fmt.Sprintf("%T", v))
+		}
+	}
+
+	return types
+}
+
+var modulePath = reflect.TypeOf(Database{}).PkgPath()
+
+// originSkipped are the packages calls reach dbresolver through,
+// with the driver and the adapters, besides the module itself
+var originSkipped = map[string]bool{
+	"database/sql":            true,
+	"github.com/jinzhu/gorm":  true,
+	"github.com/jmoiron/sqlx": true,
+}
+
+// origin is the file:line of the first caller outside this module
+// and the packages it is called through
+func origin() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+	for {
+		frame, more := frames.Next()
+		if !skipOrigin(funcPackage(frame.Function)) {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+
+		if !more {
+			return ""
+		}
+	}
+}
+
+func skipOrigin(pkg string) bool {
+	if originSkipped[pkg] {
+		return true
+	}
+
+	// tests of the module are callers too
+	if strings.HasSuffix(pkg, "_test") {
+		return false
+	}
+
+	return pkg == modulePath || strings.HasPrefix(pkg, modulePath+"/")
+}
+
+// funcPackage is the package path of a function name as
+// reported by runtime, e.g database/sql.(*DB).QueryContext
+func funcPackage(name string) string {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot]
+	}
+
+	return name
+}
+
+// rateLimiter is a token bucket refilled at rate tokens per second
+type rateLimiter struct {
+	mu     *sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	return &rateLimiter{
+		mu:     &sync.Mutex{},
+		rate:   float64(perSecond),
+		tokens: float64(perSecond),
+		last:   time.Now(),
+	}
+}
+
+func (rl *rateLimiter) allow() bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	rl.last = now
+
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+
+	if rl.tokens < 1 {
+		return false
+	}
+
+	rl.tokens--
+	return true
+}
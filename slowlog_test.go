@@ -0,0 +1,163 @@
+package dbresolver_test
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+type slowQueries struct {
+	mu      *sync.Mutex
+	queries []dbresolver.SlowQuery
+}
+
+func (sq *slowQueries) log(q dbresolver.SlowQuery) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	sq.queries = append(sq.queries, q)
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	t.Run("logs statements over the threshold", func(t *testing.T) {
+		logged := &slowQueries{mu: &sync.Mutex{}}
+		slowLog := dbresolver.NewSlowQueryLog(dbresolver.SlowQueryOpts{
+			Threshold: 1,
+			Log:       logged.log,
+		})
+
+		database := setupEventsDB(t, dbresolver.WithInterceptors(slowLog.Interceptor()))
+
+		_, err := database.Query(`SELECT name FROM events WHERE name = 'secret' AND id > ?`, 10)
+		require.NoError(t, err)
+
+		require.Len(t, logged.queries, 1)
+
+		q := logged.queries[0]
+		require.Equal(t, dbresolver.OpQuery, q.Operation)
+		require.Equal(t, "SELECT name FROM events WHERE name = ? AND id > ?", q.Statement)
+		require.Equal(t, []string{"int"}, q.ArgTypes)
+		require.Equal(t, "events_read", q.Node)
+		require.Equal(t, dbresolver.RoleReplica, q.Role)
+		require.Contains(t, q.Origin, "slowlog_test.go")
+		require.Empty(t, q.Plan)
+	})
+
+	t.Run("fast statements are not logged", func(t *testing.T) {
+		logged := &slowQueries{mu: &sync.Mutex{}}
+		database := setupEventsDB(t, dbresolver.WithSlowQueryLog(dbresolver.SlowQueryOpts{Log: logged.log}))
+
+		_, err := database.Query(`SELECT name FROM events`)
+		require.NoError(t, err)
+
+		require.Empty(t, logged.queries)
+	})
+
+	t.Run("sampling and rate limiting", func(t *testing.T) {
+		logged := &slowQueries{mu: &sync.Mutex{}}
+		slowLog := dbresolver.NewSlowQueryLog(dbresolver.SlowQueryOpts{
+			Threshold:   1,
+			SampleEvery: 2,
+			RateLimit:   2,
+			Log:         logged.log,
+		})
+
+		database := setupEventsDB(t, dbresolver.WithInterceptors(slowLog.Interceptor()))
+
+		for i := 0; i < 10; i++ {
+			_, err := database.Query(`SELECT name FROM events`)
+			require.NoError(t, err)
+		}
+
+		// 5 sampled, of which the burst of 2 passes the limiter
+		require.Len(t, logged.queries, 2)
+		require.Equal(t, uint64(8), slowLog.Suppressed())
+	})
+
+	t.Run("captures the query plan", func(t *testing.T) {
+		logged := &slowQueries{mu: &sync.Mutex{}}
+		slowLog := dbresolver.NewSlowQueryLog(dbresolver.SlowQueryOpts{
+			Threshold: 1,
+			Explain:   true,
+			Log:       logged.log,
+		})
+
+		database := setupEventsDB(t, dbresolver.WithInterceptors(slowLog.Interceptor()))
+
+		_, err := database.Query(`SELECT name FROM events WHERE name = ?`, "a")
+		require.NoError(t, err)
+
+		slowLog.Flush()
+
+		require.Len(t, logged.queries, 1)
+		require.NoError(t, logged.queries[0].PlanErr)
+		require.True(t, strings.Contains(logged.queries[0].Plan, "SCAN"), logged.queries[0].Plan)
+		require.Equal(t, []string{"string(1)"}, logged.queries[0].ArgTypes)
+	})
+
+	t.Run("captures the plan of rotating nodes", func(t *testing.T) {
+		logged := &slowQueries{mu: &sync.Mutex{}}
+		slowLog := dbresolver.NewSlowQueryLog(dbresolver.SlowQueryOpts{
+			Threshold: 1,
+			Explain:   true,
+			Log:       logged.log,
+		})
+
+		factory := dbresolver.DSNConnector(&sqlite3.SQLiteDriver{}, func(dbresolver.Credentials) string {
+			return "./tmp/events.db"
+		})
+		provider := dbresolver.CredentialProviderFunc(func(context.Context) (dbresolver.Credentials, error) {
+			return dbresolver.Credentials{}, nil
+		})
+
+		rotating := dbresolver.OpenRotating(factory, provider)
+		t.Cleanup(func() { rotating.Close() })
+
+		database, err := dbresolver.New(
+			dbresolver.DBConfig{Master: dbresolver.AsMaster(rotating, "write")},
+			dbresolver.WithInterceptors(slowLog.Interceptor()),
+		)
+		require.NoError(t, err)
+
+		_, err = database.Query(`SELECT name FROM events WHERE name = ?`, "a")
+		require.NoError(t, err)
+
+		slowLog.Flush()
+
+		require.Len(t, logged.queries, 1)
+		require.NoError(t, logged.queries[0].PlanErr)
+		require.True(t, strings.Contains(logged.queries[0].Plan, "SCAN"), logged.queries[0].Plan)
+	})
+
+	t.Run("sanitize keeps placeholders", func(t *testing.T) {
+		require.Equal(t,
+			"SELECT name FROM events WHERE id = $1 AND score > ? AND name = ? AND tag = $12",
+			dbresolver.SanitizeStatement("SELECT name FROM events WHERE id = $1 AND score > 4.5 AND name = 'x1' AND tag = $12"),
+		)
+		require.Equal(t, "SELECT t1.id FROM t1 WHERE id = ?", dbresolver.SanitizeStatement("SELECT t1.id FROM t1 WHERE id = 10"))
+	})
+
+	t.Run("origin skips database/sql", func(t *testing.T) {
+		logged := &slowQueries{mu: &sync.Mutex{}}
+		database := setupEventsDB(t, dbresolver.WithSlowQueryLog(dbresolver.SlowQueryOpts{
+			Threshold: 1,
+			Log:       logged.log,
+		}))
+
+		sqlDB := sql.OpenDB(dbresolver.Connector(database))
+		defer sqlDB.Close()
+
+		rows, err := sqlDB.Query(`SELECT name FROM events`)
+		require.NoError(t, err)
+		require.NoError(t, rows.Close())
+
+		require.Len(t, logged.queries, 1)
+		require.Contains(t, logged.queries[0].Origin, "slowlog_test.go")
+	})
+}
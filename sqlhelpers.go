@@ -4,7 +4,6 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
-	"errors"
 	"fmt"
 )
 
@@ -45,6 +44,8 @@ func ToRows(rows *sql.Rows) (Rows, error) {
 	return results, nil
 }
 
+// ToRow reads the first row, and closes rows.
+// It returns sql.ErrNoRows when there is none.
 func ToRow(rows *sql.Rows) (*Row, error) {
 	defer rows.Close()
 
@@ -53,6 +54,14 @@ func ToRow(rows *sql.Rows) (*Row, error) {
 		return nil, err
 	}
 
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, sql.ErrNoRows
+	}
+
 	values := make(Row, len(columns))
 	valuePtrs := make(Row, len(columns))
 
@@ -60,27 +69,11 @@ func ToRow(rows *sql.Rows) (*Row, error) {
 		valuePtrs[i] = &values[i]
 	}
 
-	if rows.Next() {
-		values := make(Row, len(columns))
-		valuePtrs := make(Row, len(columns))
-
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		err = rows.Scan(valuePtrs...)
-		if err != nil {
-			return nil, err
-		}
-
-		return &values, nil
-	}
-
-	if err := rows.Err(); err != nil {
+	if err := rows.Scan(valuePtrs...); err != nil {
 		return nil, err
 	}
 
-	return nil, errors.New("something_went_wrong")
+	return &values, nil
 }
 
 func HashValues(values ...interface{}) string {
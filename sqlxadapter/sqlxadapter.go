@@ -0,0 +1,88 @@
+// Package sqlxadapter gives a dbresolver database the API of *sqlx.DB.
+//
+// Teams on sqlx switch to read/write splitting by changing the
+// constructor only:
+//
+//	db := sqlxadapter.New(database, "postgres")
+//
+//	err := db.Get(&user, `SELECT * FROM users WHERE id = $1`, id)
+//	_, err = db.NamedExec(`INSERT INTO users (name) VALUES (:name)`, user)
+//
+// sqlx binds named parameters before the statement reaches the database,
+// so hooks and interceptors see the bound SQL and positional args. Reads
+// follow the database mode, writes always run on master, and transactions
+// stay on the node they were started on.
+package sqlxadapter
+
+import (
+	"database/sql"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/jmoiron/sqlx"
+)
+
+// DB is a *sqlx.DB routing its statements through a dbresolver database.
+// Get, Select, NamedExec, NamedQuery and the other methods of *sqlx.DB
+// keep their semantics.
+type DB struct {
+	*sqlx.DB
+
+	database *dbresolver.Database
+	modes    *modes
+}
+
+// modes are the *sqlx.DB of each mode, opened once and shared.
+// base follows the mode of the database.
+type modes struct {
+	base  *sqlx.DB
+	read  *sqlx.DB
+	write *sqlx.DB
+}
+
+// New wraps database. driverName is the driver of the nodes, which
+// sqlx needs to pick the bind variables, like $1 for postgres.
+func New(database *dbresolver.Database, driverName string) *DB {
+	open := func(d *dbresolver.Database) *sqlx.DB {
+		return sqlx.NewDb(sql.OpenDB(dbresolver.Connector(d)), driverName)
+	}
+
+	base := open(database)
+
+	return &DB{
+		DB:       base,
+		database: database,
+		modes: &modes{
+			base:  base,
+			read:  open(database.WithMode(dbresolver.DbReadMode)),
+			write: open(database.WithMode(dbresolver.DbWriteMode)),
+		},
+	}
+}
+
+// WithMode returns a DB forcing the mode of reads,
+// like Database.WithMode does.
+func (db *DB) WithMode(mode dbresolver.DbActionMode) *DB {
+	forced := *db
+	forced.database = db.database.WithMode(mode)
+	forced.DB = db.modes.read
+
+	if mode == dbresolver.DbWriteMode {
+		forced.DB = db.modes.write
+	}
+
+	return &forced
+}
+
+// Database is the wrapped database.
+func (db *DB) Database() *dbresolver.Database {
+	return db.database
+}
+
+// Close releases the *sqlx.DB of every mode, for db and
+// the copies made with WithMode. The wrapped database stays open.
+func (db *DB) Close() error {
+	db.modes.read.Close()
+	db.modes.write.Close()
+
+	return db.modes.base.Close()
+}
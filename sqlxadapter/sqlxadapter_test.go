@@ -0,0 +1,139 @@
+package sqlxadapter_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/go-batteries/dbresolver/sqlxadapter"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+type User struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+const schema = `CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY, name TEXT); DELETE FROM users;`
+
+// newAdapter builds the adapter on two sqlite files. The replica is seeded
+// through sqlx before the resolver wraps it, with a user master never gets,
+// so that reading it back tells the statement was routed to the replica.
+func newAdapter(t *testing.T, opts ...dbresolver.DataBaseOpts) (*sqlxadapter.DB, *hooks.EventStore) {
+	t.Helper()
+
+	master := sqlx.MustOpen("sqlite3", "../tmp/sqlx_write.db")
+	replica := sqlx.MustOpen("sqlite3", "../tmp/sqlx_read.db")
+
+	for _, node := range []*sqlx.DB{master, replica} {
+		node := node
+		t.Cleanup(func() { node.Close() })
+
+		node.MustExec(schema)
+	}
+
+	_, err := replica.NamedExec(`INSERT INTO users (id, name) VALUES (:id, :name)`, User{ID: 1, Name: "replicated"})
+	require.NoError(t, err)
+
+	store := hooks.NewEventStore()
+	opts = append([]dbresolver.DataBaseOpts{dbresolver.WithHooks(store)}, opts...)
+
+	database, err := dbresolver.New(dbresolver.DBConfig{
+		Master:   dbresolver.AsMaster(master.DB, "write"),
+		Replicas: []*dbresolver.ResolverDB{dbresolver.AsReplica(replica.DB, "read")},
+	}, opts...)
+	require.NoError(t, err)
+
+	db := sqlxadapter.New(database, "sqlite3")
+	t.Cleanup(func() { db.Close() })
+
+	return db, store
+}
+
+func TestSqlx(t *testing.T) {
+	t.Run("routes named writes to master and reads to replicas", func(t *testing.T) {
+		db, store := newAdapter(t)
+
+		nodes := []string{}
+		store.On(dbresolver.EventAfterQueryRun, func(payload interface{}) hooks.Result {
+			nodes = append(nodes, payload.(dbresolver.AfterQueryEvent).Node)
+			return hooks.Result{}
+		})
+
+		_, err := db.NamedExec(`INSERT INTO users (id, name) VALUES (:id, :name)`, User{ID: 2, Name: "jane"})
+		require.NoError(t, err)
+
+		user := User{}
+		require.NoError(t, db.Get(&user, `SELECT * FROM users WHERE id = ?`, 1))
+		require.Equal(t, "replicated", user.Name)
+
+		users := []User{}
+		require.NoError(t, db.Select(&users, `SELECT * FROM users`))
+		require.Equal(t, []User{{ID: 1, Name: "replicated"}}, users)
+
+		require.Equal(t, []string{"write", "read", "read"}, nodes)
+
+		// written on master only
+		require.ErrorIs(t, db.Get(&user, `SELECT * FROM users WHERE id = ?`, 2), sql.ErrNoRows)
+		require.NoError(t, db.WithMode(dbresolver.DbWriteMode).Get(&user, `SELECT * FROM users WHERE id = ?`, 2))
+		require.Equal(t, "jane", user.Name)
+	})
+
+	t.Run("named queries", func(t *testing.T) {
+		db, _ := newAdapter(t)
+
+		rows, err := db.NamedQuery(`SELECT * FROM users WHERE name = :name`, map[string]interface{}{"name": "replicated"})
+		require.NoError(t, err)
+		defer rows.Close()
+
+		users := []User{}
+		for rows.Next() {
+			user := User{}
+			require.NoError(t, rows.StructScan(&user))
+			users = append(users, user)
+		}
+
+		require.NoError(t, rows.Err())
+		require.Equal(t, []User{{ID: 1, Name: "replicated"}}, users)
+	})
+
+	t.Run("transactions stay on master", func(t *testing.T) {
+		db, _ := newAdapter(t)
+
+		tx, err := db.Beginx()
+		require.NoError(t, err)
+
+		_, err = tx.NamedExec(`INSERT INTO users (id, name) VALUES (:id, :name)`, User{ID: 3, Name: "john"})
+		require.NoError(t, err)
+
+		users := []User{}
+		require.NoError(t, tx.Select(&users, `SELECT * FROM users`))
+		require.Equal(t, []User{{ID: 3, Name: "john"}}, users)
+
+		require.NoError(t, tx.Rollback())
+	})
+
+	t.Run("reads are coalesced", func(t *testing.T) {
+		db, store := newAdapter(t, dbresolver.WithQueryQualescer(
+			dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour)),
+		))
+
+		coalesced := []bool{}
+		store.On(dbresolver.EventAfterQueryRun, func(payload interface{}) hooks.Result {
+			coalesced = append(coalesced, payload.(dbresolver.AfterQueryEvent).Coalesced)
+			return hooks.Result{}
+		})
+
+		for i := 0; i < 2; i++ {
+			users := []User{}
+			require.NoError(t, db.Select(&users, `SELECT * FROM users`))
+			require.Len(t, users, 1)
+		}
+
+		require.Equal(t, []bool{false, true}, coalesced)
+	})
+}
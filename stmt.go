@@ -0,0 +1,201 @@
+package dbresolver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+var ErrorStmtClosed = errors.New("statement is closed")
+
+// Stmt is a statement prepared on the nodes of a database. It is prepared
+// lazily on each node the first time the balancer selects it, and each call
+// is routed like the same call on the database. database/sql prepares it
+// again on the connections opened after a reconnect, and a node replaced by
+// Reload gets the statement prepared again on its new *sql.DB.
+type Stmt struct {
+	db    *Database
+	query string
+
+	mu     *sync.Mutex
+	stmts  map[*sql.DB]*sql.Stmt
+	closed bool
+}
+
+func (d *Database) Prepare(query string) (*Stmt, error) {
+	return d.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext returns a statement for query, without reaching a node.
+// Errors in query are returned by the first call running it.
+func (d *Database) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	return &Stmt{
+		db:    d,
+		query: query,
+		mu:    &sync.Mutex{},
+		stmts: map[*sql.DB]*sql.Stmt{},
+	}, nil
+}
+
+func (s *Stmt) Exec(values ...interface{}) (sql.Result, error) {
+	return s.ExecContext(context.Background(), values...)
+}
+
+func (s *Stmt) ExecContext(ctx context.Context, values ...interface{}) (sql.Result, error) {
+	res, err := s.db.run(ctx, s.info(OpExec, values), s.db.exec)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Result, nil
+}
+
+func (s *Stmt) Query(values ...interface{}) (Rows, error) {
+	return s.QueryContext(context.Background(), values...)
+}
+
+func (s *Stmt) QueryContext(ctx context.Context, values ...interface{}) (Rows, error) {
+	result, err := s.db.queryResultSet(ctx, s.info(OpQuery, values))
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Rows, nil
+}
+
+func (s *Stmt) QueryResultSet(values ...interface{}) (*ResultSet, error) {
+	return s.QueryResultSetContext(context.Background(), values...)
+}
+
+func (s *Stmt) QueryResultSetContext(ctx context.Context, values ...interface{}) (*ResultSet, error) {
+	return s.db.queryResultSet(ctx, s.info(OpQuery, values))
+}
+
+func (s *Stmt) QueryRow(values ...interface{}) *ResultRow {
+	return s.QueryRowContext(context.Background(), values...)
+}
+
+func (s *Stmt) QueryRowContext(ctx context.Context, values ...interface{}) *ResultRow {
+	return s.db.queryRow(ctx, s.info(OpQueryRow, values))
+}
+
+// Close closes the statement on every node it was prepared on.
+func (s *Stmt) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	var err error
+	for db, stmt := range s.stmts {
+		if closeErr := stmt.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+
+		delete(s.stmts, db)
+	}
+
+	return err
+}
+
+func (s *Stmt) info(op Operation, values []interface{}) *QueryInfo {
+	return &QueryInfo{Op: op, Statement: s.query, Args: values, Prepared: true, stmt: s}
+}
+
+// on returns the statement prepared on node, preparing it on a first use.
+// It is prepared without holding the lock, so that a slow node does not
+// block the calls on the other nodes. Of two concurrent first uses of a
+// node, the statement of the last one is closed.
+func (s *Stmt) on(ctx context.Context, node *ResolverDB) (*sql.Stmt, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrorStmtClosed
+	}
+
+	if stmt, ok := s.stmts[node.DB]; ok {
+		s.mu.Unlock()
+		return stmt, nil
+	}
+
+	s.prune()
+	s.mu.Unlock()
+
+	stmt, err := node.PrepareContext(ctx, s.query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		stmt.Close()
+		return nil, ErrorStmtClosed
+	}
+
+	if prepared, ok := s.stmts[node.DB]; ok {
+		stmt.Close()
+		return prepared, nil
+	}
+
+	s.stmts[node.DB] = stmt
+	return stmt, nil
+}
+
+// prune forgets the statements of nodes removed by a reload. They are not
+// closed, a caller may still be using them until Reload drained the node,
+// and closing the *sql.DB of the node closes them.
+func (s *Stmt) prune() {
+	used := map[*sql.DB]bool{}
+	for _, node := range s.db.Nodes() {
+		used[node.DB] = true
+	}
+
+	for db := range s.stmts {
+		if !used[db] {
+			delete(s.stmts, db)
+		}
+	}
+}
+
+// prepared is the statement info runs with, nil when it was
+// not prepared, or when an interceptor rewrote the statement.
+func (info *QueryInfo) prepared() *Stmt {
+	if info.stmt == nil || info.stmt.query != info.Statement {
+		return nil
+	}
+
+	return info.stmt
+}
+
+func queryNode(ctx context.Context, node *ResolverDB, prepared *Stmt, stmt string, values []interface{}) (*sql.Rows, error) {
+	if prepared == nil {
+		return node.QueryContext(ctx, stmt, values...)
+	}
+
+	ps, err := prepared.on(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	return ps.QueryContext(ctx, values...)
+}
+
+func execNode(ctx context.Context, node *ResolverDB, prepared *Stmt, stmt string, values []interface{}) (sql.Result, error) {
+	if prepared == nil {
+		return node.ExecContext(ctx, stmt, values...)
+	}
+
+	ps, err := prepared.on(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	return ps.ExecContext(ctx, values...)
+}
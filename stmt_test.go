@@ -0,0 +1,183 @@
+package dbresolver_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/stretchr/testify/require"
+)
+
+func openItemsDB(t *testing.T, path string, name string) *sql.DB {
+	t.Helper()
+
+	db := openDB(t, path)
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS items (id INTEGER PRIMARY KEY, name TEXT); DELETE FROM items;`)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`INSERT INTO items (id, name) VALUES (1, ?)`, name)
+	require.NoError(t, err)
+
+	return db
+}
+
+func setupStmtDB(t *testing.T, opts ...dbresolver.DataBaseOpts) *dbresolver.Database {
+	t.Helper()
+
+	database, err := dbresolver.New(dbresolver.DBConfig{
+		Master:   dbresolver.AsMaster(openItemsDB(t, "./tmp/stmt_write.db", "master"), "write"),
+		Replicas: []*dbresolver.ResolverDB{dbresolver.AsSyncReplica(openItemsDB(t, "./tmp/stmt_read.db", "replica"), "read")},
+	}, opts...)
+	require.NoError(t, err)
+
+	return database
+}
+
+func TestPrepare(t *testing.T) {
+	t.Run("routes like the database", func(t *testing.T) {
+		store := hooks.NewEventStore()
+		before := collect(store, dbresolver.EventBeforeQueryRun)
+		after := collect(store, dbresolver.EventAfterQueryRun)
+
+		database := setupStmtDB(t, dbresolver.WithHooks(store))
+
+		stmt, err := database.Prepare(`SELECT name FROM items WHERE id = ?`)
+		require.NoError(t, err)
+		defer stmt.Close()
+
+		var name string
+		require.NoError(t, stmt.QueryRow(1).Scan(&name))
+		require.Equal(t, "replica", name)
+
+		require.True(t, (*before)[0].(dbresolver.BeforeQueryEvent).Prepared)
+		event := (*after)[0].(dbresolver.AfterQueryEvent)
+		require.True(t, event.Prepared)
+		require.Equal(t, "read", event.Node)
+
+		insert, err := database.Prepare(`INSERT INTO items (name) VALUES (?)`)
+		require.NoError(t, err)
+		defer insert.Close()
+
+		_, err = insert.Exec("rejected")
+		require.ErrorIs(t, err, dbresolver.ErrorInvalidDBMode)
+
+		insert, err = database.WithMode(dbresolver.DbWriteMode).Prepare(`INSERT INTO items (name) VALUES (?)`)
+		require.NoError(t, err)
+		defer insert.Close()
+
+		for _, name := range []string{"first", "second"} {
+			result, err := insert.Exec(name)
+			require.NoError(t, err)
+
+			affected, err := result.RowsAffected()
+			require.NoError(t, err)
+			require.Equal(t, int64(1), affected)
+		}
+
+		require.Equal(t, "write", (*after)[len(*after)-1].(dbresolver.AfterQueryEvent).Node)
+
+		rows, err := database.WithMode(dbresolver.DbWriteMode).Query(`SELECT name FROM items ORDER BY id`)
+		require.NoError(t, err)
+		require.Equal(t, dbresolver.Rows{{"master"}, {"first"}, {"second"}}, rows)
+	})
+
+	t.Run("prepares again on reloaded nodes", func(t *testing.T) {
+		database := setupStmtDB(t)
+
+		stmt, err := database.Prepare(`SELECT name FROM items WHERE id = ?`)
+		require.NoError(t, err)
+		defer stmt.Close()
+
+		rows, err := stmt.Query(1)
+		require.NoError(t, err)
+		require.Equal(t, dbresolver.Rows{{"replica"}}, rows)
+
+		config := database.CurrentConfig()
+		config.Replicas = []*dbresolver.ResolverDB{
+			dbresolver.AsSyncReplica(openItemsDB(t, "./tmp/stmt_other.db", "other"), "read"),
+		}
+		require.NoError(t, database.Reload(config))
+
+		result, err := stmt.QueryResultSet(1)
+		require.NoError(t, err)
+		require.Equal(t, []string{"name"}, result.Columns.Names())
+		require.Equal(t, dbresolver.Rows{{"other"}}, result.Rows)
+	})
+
+	t.Run("a node slow to prepare does not block the others", func(t *testing.T) {
+		slow := openItemsDB(t, "./tmp/stmt_read.db", "slow")
+		slow.SetMaxOpenConns(1)
+
+		// preparing on slow waits for its only connection
+		busy, err := slow.Conn(context.Background())
+		require.NoError(t, err)
+
+		database, err := dbresolver.New(dbresolver.DBConfig{
+			Master: dbresolver.AsMaster(openItemsDB(t, "./tmp/stmt_write.db", "master"), "write"),
+			Replicas: []*dbresolver.ResolverDB{
+				dbresolver.AsSyncReplica(slow, "slow"),
+				dbresolver.AsSyncReplica(openItemsDB(t, "./tmp/stmt_other.db", "fast"), "fast"),
+			},
+			Policy: dbresolver.NewRoundRobalancer(2),
+		})
+		require.NoError(t, err)
+
+		stmt, err := database.Prepare(`SELECT name FROM items WHERE id = ?`)
+		require.NoError(t, err)
+		defer stmt.Close()
+		defer busy.Close()
+
+		scan := func() <-chan string {
+			names := make(chan string, 1)
+			go func() {
+				var name string
+				if err := stmt.QueryRow(1).Scan(&name); err != nil {
+					name = err.Error()
+				}
+
+				names <- name
+			}()
+
+			return names
+		}
+
+		blocked := scan()
+		require.Eventually(t, func() bool { return slow.Stats().WaitCount > 0 }, time.Second, time.Millisecond)
+
+		select {
+		case name := <-scan():
+			require.Equal(t, "fast", name)
+		case <-time.After(time.Second):
+			t.Fatal("the fast node waited for the slow one")
+		}
+
+		require.NoError(t, busy.Close())
+		require.Equal(t, "slow", <-blocked)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		database := setupStmtDB(t)
+
+		stmt, err := database.Prepare(`SELECT missing FROM items`)
+		require.NoError(t, err)
+
+		_, err = stmt.Query()
+		require.ErrorContains(t, err, "no such column")
+
+		stmt, err = database.Prepare(`SELECT name FROM items`)
+		require.NoError(t, err)
+
+		_, err = stmt.Query()
+		require.NoError(t, err)
+
+		require.NoError(t, stmt.Close())
+		require.NoError(t, stmt.Close())
+
+		_, err = stmt.Query()
+		require.ErrorIs(t, err, dbresolver.ErrorStmtClosed)
+	})
+}
@@ -0,0 +1,146 @@
+package dbresolver
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// StreamRows is a *sql.Rows read as it comes, bound to the node it was
+// opened on. The node is released, and EventAfterQueryRun emitted with
+// the duration and number of rows read, once it is closed, or once Next
+// has read every row.
+type StreamRows struct {
+	*sql.Rows
+
+	db    *Database
+	ctx   context.Context
+	event AfterQueryEvent
+	node  *ResolverDB
+	start time.Time
+	read  int64
+
+	// closed is set once the node was released
+	closed uint32
+}
+
+func (d *Database) QueryStream(stmt string, values ...interface{}) (*StreamRows, error) {
+	return d.QueryStreamContext(context.Background(), stmt, values...)
+}
+
+// QueryStreamContext runs a query without reading its rows in memory, for
+// results too large for Query. Streams are never coalesced or cached.
+// Interceptors see the opening of the stream, as OpQueryStream, and not
+// the reading of its rows, so they must not answer it with their own rows.
+func (d *Database) QueryStreamContext(ctx context.Context, stmt string, values ...interface{}) (*StreamRows, error) {
+	res, err := d.run(ctx, &QueryInfo{Op: OpQueryStream, Statement: stmt, Args: values}, d.openStream)
+	if err != nil {
+		// an interceptor failed after the stream was opened
+		if res.Stream != nil {
+			res.Stream.discard()
+		}
+
+		return nil, err
+	}
+
+	if res.Stream == nil {
+		return nil, ErrorInvalidData
+	}
+
+	return res.Stream, nil
+}
+
+// openStream is the terminal of QueryStreamContext. On success, the node
+// stays acquired by the stream, which emits EventAfterQueryRun once closed.
+func (d *Database) openStream(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+	stmt, values := info.Statement, info.Args
+
+	source := d.selectSource(ctx)
+
+	if isDML(strings.ToLower(stmt)) {
+		source.release()
+		source = d.getMaster(ctx)
+
+		if d.koalescer != nil {
+			d.koalescer.ForgetWithContext(ctx, ToKey(stmt, values...))
+		}
+	}
+
+	info.Node = source
+
+	stream := &StreamRows{
+		db:  d,
+		ctx: ctx,
+		event: AfterQueryEvent{
+			Operation: OpQueryStream,
+			Statement: stmt,
+			Args:      values,
+			Node:      source.Name,
+			Role:      source.Role(),
+		},
+		node:  source,
+		start: time.Now(),
+	}
+
+	rows, err := source.QueryContext(ctx, stmt, values...)
+	if err != nil {
+		source.release()
+		return QueryResult{}, err
+	}
+
+	stream.Rows = rows
+	return QueryResult{Stream: stream}, nil
+}
+
+// Node is the node the stream reads from.
+func (r *StreamRows) Node() *ResolverDB {
+	return r.node
+}
+
+func (r *StreamRows) Next() bool {
+	if r.Rows.Next() {
+		r.read++
+		return true
+	}
+
+	r.finish(r.Rows.Err())
+	return false
+}
+
+func (r *StreamRows) Close() error {
+	err := r.Rows.Close()
+	if err == nil {
+		err = r.Rows.Err()
+	}
+
+	r.finish(err)
+	return err
+}
+
+// discard closes the stream and releases the node, without emitting
+// the query events, which were emitted for the error already.
+func (r *StreamRows) discard() {
+	r.Rows.Close()
+
+	if atomic.CompareAndSwapUint32(&r.closed, 0, 1) {
+		r.node.release()
+	}
+}
+
+// finish releases the node, and emits the query events, on the first call
+func (r *StreamRows) finish(err error) {
+	if !atomic.CompareAndSwapUint32(&r.closed, 0, 1) {
+		return
+	}
+
+	r.node.release()
+
+	event := r.event
+	event.Duration = time.Since(r.start)
+	event.Rows = r.read
+	event.Err = err
+
+	r.db.emitAfterQuery(r.ctx, event)
+}
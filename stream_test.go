@@ -0,0 +1,188 @@
+package dbresolver_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryStream(t *testing.T) {
+	t.Run("reports the rows read on close", func(t *testing.T) {
+		store := hooks.NewEventStore()
+		before := collect(store, dbresolver.EventBeforeQueryRun)
+		after := collect(store, dbresolver.EventAfterQueryRun)
+
+		database := setupTypedDB(t, dbresolver.WithHooks(store), dbresolver.WithQueryQualescer(
+			dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour)),
+		))
+
+		stream, err := database.QueryStream(`SELECT name FROM users ORDER BY id`)
+		require.NoError(t, err)
+		require.Equal(t, "write", stream.Node().Name)
+
+		require.Len(t, *before, 1)
+		require.Equal(t, dbresolver.OpQueryStream, (*before)[0].(dbresolver.BeforeQueryEvent).Operation)
+		require.Empty(t, *after)
+
+		require.True(t, stream.Next())
+
+		var name string
+		require.NoError(t, stream.Scan(&name))
+		require.Equal(t, "jane", name)
+
+		require.NoError(t, stream.Close())
+		require.NoError(t, stream.Close())
+
+		require.Len(t, *after, 1)
+		event := (*after)[0].(dbresolver.AfterQueryEvent)
+		require.Equal(t, int64(1), event.Rows)
+		require.Equal(t, "write", event.Node)
+		require.Positive(t, event.Duration)
+		require.False(t, event.Coalesced)
+
+		// reading every row closes the stream
+		stream, err = database.QueryStream(`SELECT name FROM users ORDER BY id`)
+		require.NoError(t, err)
+
+		for stream.Next() {
+		}
+
+		require.NoError(t, stream.Err())
+		require.Len(t, *after, 2)
+		require.Equal(t, int64(2), (*after)[1].(dbresolver.AfterQueryEvent).Rows)
+		require.False(t, (*after)[1].(dbresolver.AfterQueryEvent).Coalesced)
+	})
+
+	t.Run("errors emit the query events", func(t *testing.T) {
+		store := hooks.NewEventStore()
+		failed := collect(store, dbresolver.EventQueryError)
+
+		database := setupTypedDB(t, dbresolver.WithHooks(store))
+
+		_, err := database.QueryStream(`SELECT missing FROM users`)
+		require.Error(t, err)
+
+		require.Len(t, *failed, 1)
+		require.Equal(t, err, (*failed)[0].(dbresolver.AfterQueryEvent).Err)
+	})
+
+	t.Run("holds the node until closed", func(t *testing.T) {
+		database := setupTypedDB(t)
+
+		stream, err := database.QueryStream(`SELECT name FROM users`)
+		require.NoError(t, err)
+
+		reloaded := make(chan error)
+		go func() {
+			reloaded <- database.Reload(dbresolver.DBConfig{
+				Master: dbresolver.AsMaster(openDB(t, "./tmp/replica.db"), "write"),
+			})
+		}()
+
+		select {
+		case <-reloaded:
+			t.Fatal("reload returned before the stream was closed")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		require.NoError(t, stream.Close())
+		require.NoError(t, <-reloaded)
+	})
+
+	t.Run("interceptors see the opening", func(t *testing.T) {
+		store := hooks.NewEventStore()
+		after := collect(store, dbresolver.EventAfterQueryRun)
+
+		var seen []dbresolver.QueryInfo
+
+		database := setupTypedDB(t, dbresolver.WithHooks(store), dbresolver.WithInterceptors(
+			func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				info.Statement += ` WHERE id = 2`
+
+				res, err := next(ctx, info)
+				seen = append(seen, *info)
+
+				return res, err
+			},
+		))
+
+		stream, err := database.QueryStream(`SELECT name FROM users`)
+		require.NoError(t, err)
+
+		require.Len(t, seen, 1)
+		require.Equal(t, dbresolver.OpQueryStream, seen[0].Op)
+		require.Equal(t, stream.Node(), seen[0].Node)
+		require.Empty(t, *after)
+
+		var names []string
+		for stream.Next() {
+			var name string
+			require.NoError(t, stream.Scan(&name))
+
+			names = append(names, name)
+		}
+
+		require.Equal(t, []string{"john"}, names)
+
+		require.Len(t, *after, 1)
+		event := (*after)[0].(dbresolver.AfterQueryEvent)
+		require.Equal(t, `SELECT name FROM users WHERE id = 2`, event.Statement)
+		require.Equal(t, int64(1), event.Rows)
+	})
+
+	t.Run("timeouts do not cancel the stream", func(t *testing.T) {
+		database := setupTypedDB(t, dbresolver.WithInterceptors(dbresolver.TimeoutInterceptor(time.Minute)))
+
+		stream, err := database.QueryStream(`SELECT name FROM users ORDER BY id`)
+		require.NoError(t, err)
+		defer stream.Close()
+
+		read := 0
+		for stream.Next() {
+			read++
+		}
+
+		require.NoError(t, stream.Err())
+		require.Equal(t, 2, read)
+	})
+
+	t.Run("failing interceptors close the stream", func(t *testing.T) {
+		store := hooks.NewEventStore()
+		after := collect(store, dbresolver.EventAfterQueryRun)
+
+		denied := errors.New("denied")
+
+		database := setupTypedDB(t, dbresolver.WithHooks(store), dbresolver.WithInterceptors(
+			func(ctx context.Context, info *dbresolver.QueryInfo, next dbresolver.QueryFunc) (dbresolver.QueryResult, error) {
+				res, _ := next(ctx, info)
+				return res, denied
+			},
+		))
+
+		_, err := database.QueryStream(`SELECT name FROM users`)
+		require.ErrorIs(t, err, denied)
+
+		require.Len(t, *after, 1)
+		require.Equal(t, denied, (*after)[0].(dbresolver.AfterQueryEvent).Err)
+
+		// the node was released
+		reloaded := make(chan error, 1)
+		go func() {
+			reloaded <- database.Reload(dbresolver.DBConfig{
+				Master: dbresolver.AsMaster(openDB(t, "./tmp/replica.db"), "write"),
+			})
+		}()
+
+		select {
+		case err := <-reloaded:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("reload waits for the discarded stream")
+		}
+	})
+}
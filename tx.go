@@ -0,0 +1,207 @@
+package dbresolver
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	stmtBegin    = "BEGIN"
+	stmtCommit   = "COMMIT"
+	stmtRollback = "ROLLBACK"
+)
+
+// Tx is a transaction pinned to the node it was started on.
+// Statements inside it go through the interceptor chain and hooks,
+// but are never coalesced or cached.
+type Tx struct {
+	tx   *sql.Tx
+	db   *Database
+	node *ResolverDB
+	// done is set once the transaction ended, and the node released
+	done uint32
+	// ctx is the context the transaction was started with, as seen
+	// past the interceptors. Commit and Rollback run with it, so that
+	// interceptors can carry state, like a span, across the transaction.
+	ctx context.Context
+}
+
+func (d *Database) Begin() (*Tx, error) {
+	return d.BeginTx(context.Background(), nil)
+}
+
+// BeginTx starts a transaction on master. Read only transactions
+// follow the database mode, and run on a replica in read mode.
+func (d *Database) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	info := &QueryInfo{Op: OpBegin, Statement: stmtBegin}
+	txCtx := ctx
+
+	res, err := d.run(ctx, info, func(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+		txCtx = ctx
+
+		if opts != nil && opts.ReadOnly {
+			info.Node = d.selectSource(ctx)
+		} else {
+			info.Node = d.getMaster(ctx)
+		}
+
+		tx, err := info.Node.BeginTx(ctx, opts)
+		if err != nil {
+			info.Node.release()
+		}
+
+		return QueryResult{Tx: tx}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{tx: res.Tx, db: d, node: info.Node, ctx: txCtx}, nil
+}
+
+func (tx *Tx) UnWrap() *sql.Tx {
+	return tx.tx
+}
+
+// Node is the node the transaction runs on.
+func (tx *Tx) Node() *ResolverDB {
+	return tx.node
+}
+
+func (tx *Tx) Exec(stmt string, values ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(context.Background(), stmt, values...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, stmt string, values ...interface{}) (sql.Result, error) {
+	info := tx.info(OpExec, stmt, values)
+
+	res, err := tx.db.run(ctx, info, func(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+		tx.forget(info)
+
+		result, err := tx.tx.ExecContext(ctx, info.Statement, info.Args...)
+		return QueryResult{Result: result}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Result, nil
+}
+
+func (tx *Tx) Query(stmt string, values ...interface{}) (Rows, error) {
+	return tx.QueryContext(context.Background(), stmt, values...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, stmt string, values ...interface{}) (Rows, error) {
+	result, err := tx.QueryResultSetContext(ctx, stmt, values...)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Rows, nil
+}
+
+func (tx *Tx) QueryResultSet(stmt string, values ...interface{}) (*ResultSet, error) {
+	return tx.QueryResultSetContext(context.Background(), stmt, values...)
+}
+
+func (tx *Tx) QueryResultSetContext(ctx context.Context, stmt string, values ...interface{}) (*ResultSet, error) {
+	info := tx.info(OpQuery, stmt, values)
+
+	var result *ResultSet
+
+	res, err := tx.db.run(ctx, info, func(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+		tx.forget(info)
+
+		rows, err := tx.tx.QueryContext(ctx, info.Statement, info.Args...)
+		if err != nil {
+			return QueryResult{}, err
+		}
+
+		result, err = ToResultSet(rows)
+		if err != nil {
+			return QueryResult{}, err
+		}
+
+		return QueryResult{Rows: result.Rows}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result == nil || !sameRows(result.Rows, res.Rows) {
+		return &ResultSet{Rows: res.Rows}, nil
+	}
+
+	return result, nil
+}
+
+func (tx *Tx) QueryRow(stmt string, values ...interface{}) *ResultRow {
+	return tx.QueryRowContext(context.Background(), stmt, values...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, stmt string, values ...interface{}) *ResultRow {
+	info := tx.info(OpQueryRow, stmt, values)
+
+	var record *Record
+
+	res, err := tx.db.run(ctx, info, func(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+		tx.forget(info)
+
+		rows, err := tx.tx.QueryContext(ctx, info.Statement, info.Args...)
+		if err != nil {
+			return QueryResult{}, err
+		}
+
+		record, err = ToRecord(rows)
+		if err != nil {
+			return QueryResult{}, err
+		}
+
+		return QueryResult{Row: &record.Values}, nil
+	})
+
+	return rowOf(record, res, err)
+}
+
+func (tx *Tx) Commit() error {
+	return tx.finish(OpCommit, stmtCommit, tx.tx.Commit)
+}
+
+func (tx *Tx) Rollback() error {
+	return tx.finish(OpRollback, stmtRollback, tx.tx.Rollback)
+}
+
+// finish ends the transaction, and releases the node on the first call
+func (tx *Tx) finish(op Operation, stmt string, fn func() error) error {
+	_, err := tx.db.run(tx.ctx, tx.info(op, stmt, nil), func(context.Context, *QueryInfo) (QueryResult, error) {
+		err := fn()
+
+		if atomic.CompareAndSwapUint32(&tx.done, 0, 1) {
+			tx.node.release()
+		}
+
+		return QueryResult{}, err
+	})
+
+	return err
+}
+
+func (tx *Tx) info(op Operation, stmt string, values []interface{}) *QueryInfo {
+	return &QueryInfo{
+		Op:        op,
+		Statement: stmt,
+		Args:      values,
+		InTx:      true,
+		Node:      tx.node,
+	}
+}
+
+// forget drops koalesced results for writes made inside the transaction
+func (tx *Tx) forget(info *QueryInfo) {
+	if tx.db.koalescer != nil && isDML(strings.ToLower(info.Statement)) {
+		tx.db.koalescer.Forget(ToKey(info.Statement, info.Args...))
+	}
+}
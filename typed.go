@@ -0,0 +1,244 @@
+package dbresolver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorUnmappedColumn = errors.New("column has no matching struct field")
+	ErrorColumnCount    = errors.New("scanning into a non struct type needs a single column")
+)
+
+// QueryAs runs a query like QueryContext, and scans each row into a T.
+//
+// Columns map to the fields of a struct T by their db tag, or their
+// lower cased name without one. Fields tagged db:"-" are skipped, and
+// the fields of embedded structs are promoted. Fields implementing
+// sql.Scanner, and nullable pointers, are scanned like with rows.Scan.
+// Any other T, like int64 or string, is scanned from a single column.
+//
+// Results shared through the koalescer are typed: a cached []T is
+// served as is, without scanning again. Callers get their own slice,
+// but pointers and slices held by T are shared with other callers.
+func QueryAs[T any](ctx context.Context, d *Database, stmt string, values ...interface{}) ([]T, error) {
+	info := &QueryInfo{Op: OpQuery, Statement: stmt, Args: values}
+
+	res, err := d.run(ctx, info, func(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+		val, err := d.query(ctx, info, typedNamespace[T]("all"), func(rows *sql.Rows) (interface{}, error) {
+			return scanAs[T](rows, false)
+		})
+		if err != nil {
+			return QueryResult{}, err
+		}
+
+		return QueryResult{Typed: val}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	typed, ok := res.Typed.([]T)
+	if !ok {
+		return nil, ErrorInvalidData
+	}
+
+	return append(make([]T, 0, len(typed)), typed...), nil
+}
+
+// QueryOneAs runs a query like QueryAs, and scans its first row.
+// It returns sql.ErrNoRows when there is none.
+func QueryOneAs[T any](ctx context.Context, d *Database, stmt string, values ...interface{}) (T, error) {
+	var zero T
+
+	info := &QueryInfo{Op: OpQueryRow, Statement: stmt, Args: values}
+
+	res, err := d.run(ctx, info, func(ctx context.Context, info *QueryInfo) (QueryResult, error) {
+		val, err := d.query(ctx, info, typedNamespace[T]("one"), func(rows *sql.Rows) (interface{}, error) {
+			found, err := scanAs[T](rows, true)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(found) == 0 {
+				return nil, sql.ErrNoRows
+			}
+
+			return found[0], nil
+		})
+		if err != nil {
+			return QueryResult{}, err
+		}
+
+		return QueryResult{Typed: val}, nil
+	})
+	if err != nil {
+		return zero, err
+	}
+
+	typed, ok := res.Typed.(T)
+	if !ok {
+		return zero, ErrorInvalidData
+	}
+
+	return typed, nil
+}
+
+// typedNamespace keeps the results of each type, and of QueryAs and
+// QueryOneAs, apart in the koalescer
+func typedNamespace[T any](kind string) string {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	return fmt.Sprintf("typed_%s:%s.%s:", kind, t.PkgPath(), t.String())
+}
+
+func scanAs[T any](rows *sql.Rows, first bool) ([]T, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	isStruct := t.Kind() == reflect.Struct && !isScannedAsIs(t)
+
+	var fields map[string][]int
+
+	if isStruct {
+		fields = structFields(t)
+
+		for _, column := range columns {
+			if _, ok := fields[column]; !ok {
+				return nil, fmt.Errorf("%w: %s in %s", ErrorUnmappedColumn, column, t)
+			}
+		}
+	} else if len(columns) != 1 {
+		return nil, fmt.Errorf("%w: got %d for %s", ErrorColumnCount, len(columns), t)
+	}
+
+	results := []T{}
+	dest := make([]interface{}, len(columns))
+
+	for rows.Next() {
+		var value T
+
+		if isStruct {
+			v := reflect.ValueOf(&value).Elem()
+
+			for i, column := range columns {
+				dest[i] = fieldAddr(v, fields[column])
+			}
+		} else {
+			dest[0] = &value
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		results = append(results, value)
+
+		if first {
+			break
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+
+	// fieldsByType caches structFields, by reflect.Type
+	fieldsByType = &sync.Map{}
+)
+
+// isScannedAsIs tells whether a struct is a value of its own,
+// instead of a set of fields to map columns to
+func isScannedAsIs(t reflect.Type) bool {
+	return t == timeType || reflect.PtrTo(t).Implements(scannerType)
+}
+
+// structFields maps column names to the index path of their field in t
+func structFields(t reflect.Type) map[string][]int {
+	if cached, ok := fieldsByType.Load(t); ok {
+		return cached.(map[string][]int)
+	}
+
+	fields := map[string][]int{}
+	collectFields(t, nil, fields)
+
+	fieldsByType.Store(t, fields)
+	return fields
+}
+
+// collectFields walks embedded structs too. A field shadows the fields of
+// the same name found deeper, like with promoted fields in Go. Pointers to
+// unexported embedded structs are skipped, reflect cannot allocate them.
+func collectFields(t reflect.Type, index []int, fields map[string][]int) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+
+		path := append(append([]int{}, index...), i)
+
+		embedded := field.Type
+		if embedded.Kind() == reflect.Ptr {
+			embedded = embedded.Elem()
+		}
+
+		if field.Anonymous && tag == "" && embedded.Kind() == reflect.Struct && !isScannedAsIs(embedded) {
+			if field.IsExported() || field.Type.Kind() != reflect.Ptr {
+				collectFields(embedded, path, fields)
+			}
+
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		if existing, ok := fields[name]; !ok || len(path) < len(existing) {
+			fields[name] = path
+		}
+	}
+}
+
+// fieldAddr returns the address of the field at path,
+// allocating the embedded pointers on the way
+func fieldAddr(v reflect.Value, path []int) interface{} {
+	for _, i := range path[:len(path)-1] {
+		v = v.Field(i)
+
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+
+			v = v.Elem()
+		}
+	}
+
+	return v.Field(path[len(path)-1]).Addr().Interface()
+}
@@ -0,0 +1,163 @@
+package dbresolver_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-batteries/dbresolver"
+	"github.com/go-batteries/dbresolver/hooks"
+	"github.com/stretchr/testify/require"
+)
+
+type upperName string
+
+func (n *upperName) Scan(src interface{}) error {
+	*n = upperName(strings.ToUpper(fmt.Sprint(src)))
+	return nil
+}
+
+type model struct {
+	ID int64 `db:"id"`
+}
+
+type Audit struct {
+	CreatedBy string `db:"created_by"`
+}
+
+type typedUser struct {
+	model
+	*Audit
+
+	Name    string          `db:"name"`
+	Display upperName       `db:"display"`
+	Email   *string         `db:"email"`
+	Score   sql.NullFloat64 `db:"score"`
+	Ignored string          `db:"-"`
+}
+
+func setupTypedDB(t *testing.T, opts ...dbresolver.DataBaseOpts) *dbresolver.Database {
+	t.Helper()
+
+	db := openDB(t, "./tmp/typed.db")
+
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, score REAL, created_by TEXT);
+		DELETE FROM users;
+		INSERT INTO users (id, name, email, score, created_by) VALUES
+			(1, 'jane', 'jane@example.com', 4.5, 'admin'),
+			(2, 'john', NULL, NULL, 'import');
+	`)
+	require.NoError(t, err)
+
+	database, err := dbresolver.New(dbresolver.DBConfig{Master: dbresolver.AsMaster(db, "write")}, opts...)
+	require.NoError(t, err)
+
+	return database
+}
+
+func TestQueryAs(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("maps columns to struct fields", func(t *testing.T) {
+		database := setupTypedDB(t)
+
+		users, err := dbresolver.QueryAs[typedUser](ctx, database,
+			`SELECT id, name, name AS display, email, score, created_by FROM users ORDER BY id`)
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+
+		require.Equal(t, int64(1), users[0].ID)
+		require.Equal(t, "jane", users[0].Name)
+		require.Equal(t, upperName("JANE"), users[0].Display)
+		require.Equal(t, "jane@example.com", *users[0].Email)
+		require.Equal(t, sql.NullFloat64{Float64: 4.5, Valid: true}, users[0].Score)
+		require.Equal(t, "admin", users[0].CreatedBy)
+
+		require.Nil(t, users[1].Email)
+		require.False(t, users[1].Score.Valid)
+		require.Equal(t, "import", users[1].CreatedBy)
+	})
+
+	t.Run("scans single columns into other types", func(t *testing.T) {
+		database := setupTypedDB(t)
+
+		names, err := dbresolver.QueryAs[string](ctx, database, `SELECT name FROM users ORDER BY id`)
+		require.NoError(t, err)
+		require.Equal(t, []string{"jane", "john"}, names)
+
+		count, err := dbresolver.QueryOneAs[int64](ctx, database, `SELECT COUNT(*) FROM users`)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), count)
+
+		_, err = dbresolver.QueryAs[string](ctx, database, `SELECT id, name FROM users`)
+		require.ErrorIs(t, err, dbresolver.ErrorColumnCount)
+	})
+
+	t.Run("first row and no rows", func(t *testing.T) {
+		database := setupTypedDB(t)
+
+		user, err := dbresolver.QueryOneAs[typedUser](ctx, database, `SELECT id, name FROM users WHERE id = ?`, 2)
+		require.NoError(t, err)
+		require.Equal(t, "john", user.Name)
+		require.Nil(t, user.Audit)
+
+		_, err = dbresolver.QueryOneAs[typedUser](ctx, database, `SELECT id FROM users WHERE id = ?`, 3)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		users, err := dbresolver.QueryAs[typedUser](ctx, database, `SELECT id FROM users WHERE id = ?`, 3)
+		require.NoError(t, err)
+		require.Empty(t, users)
+	})
+
+	t.Run("unmapped columns fail", func(t *testing.T) {
+		database := setupTypedDB(t)
+
+		_, err := dbresolver.QueryAs[typedUser](ctx, database, `SELECT id, name AS ignored FROM users`)
+		require.ErrorIs(t, err, dbresolver.ErrorUnmappedColumn)
+	})
+
+	t.Run("typed results are cached", func(t *testing.T) {
+		store := hooks.NewEventStore()
+		after := collect(store, dbresolver.EventAfterQueryRun)
+
+		database := setupTypedDB(t, dbresolver.WithHooks(store), dbresolver.WithQueryQualescer(
+			dbresolver.NewKoalescer(&dbresolver.NoopEvictor{}, dbresolver.WithStaleWhileRevalidate(time.Hour, time.Hour)),
+		))
+
+		stmt := `SELECT id, name FROM users ORDER BY id`
+
+		first, err := dbresolver.QueryAs[typedUser](ctx, database, stmt)
+		require.NoError(t, err)
+
+		first[0].Name = "changed by the caller"
+
+		second, err := dbresolver.QueryAs[typedUser](ctx, database, stmt)
+		require.NoError(t, err)
+		require.Equal(t, "jane", second[0].Name)
+
+		// other types and the untyped API never get a []typedUser
+		rows, err := database.Query(stmt)
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+
+		one, err := dbresolver.QueryOneAs[typedUser](ctx, database, stmt)
+		require.NoError(t, err)
+		require.Equal(t, "jane", one.Name)
+
+		require.Len(t, *after, 4)
+
+		coalesced := []bool{}
+		for _, payload := range *after {
+			event := payload.(dbresolver.AfterQueryEvent)
+			coalesced = append(coalesced, event.Coalesced)
+		}
+
+		require.Equal(t, []bool{false, true, false, false}, coalesced)
+		require.Equal(t, int64(2), (*after)[1].(dbresolver.AfterQueryEvent).Rows)
+		require.Equal(t, int64(1), (*after)[3].(dbresolver.AfterQueryEvent).Rows)
+	})
+}